@@ -0,0 +1,151 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wacul/transport/limit"
+)
+
+// Policy picks the backend for the request from the endpoints, which are never empty.
+type Policy interface {
+	Pick(req *http.Request, endpoints []*Endpoint) *Endpoint
+}
+
+// RoundRobin is the Policy that picks the backends in turn.
+type RoundRobin struct {
+	next uint64
+}
+
+// Pick implements the Policy interface.
+func (p *RoundRobin) Pick(req *http.Request, endpoints []*Endpoint) *Endpoint {
+	n := atomic.AddUint64(&p.next, 1) - 1
+	return endpoints[n%uint64(len(endpoints))]
+}
+
+// WeightedRoundRobin is the Policy that picks the backends in turn in proportion to their Weight.
+// It interleaves them smoothly, like a, a, b, a for the weights 3 and 1 rather than a, a, a, b.
+type WeightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Endpoint]int
+}
+
+// Pick implements the Policy interface.
+func (p *WeightedRoundRobin) Pick(req *http.Request, endpoints []*Endpoint) *Endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current == nil || len(p.current) > len(endpoints) {
+		// Forget the removed backends.
+		current := make(map[*Endpoint]int, len(endpoints))
+		for _, e := range endpoints {
+			current[e] = p.current[e]
+		}
+		p.current = current
+	}
+
+	var best *Endpoint
+	total := 0
+	for _, e := range endpoints {
+		w := e.weight()
+		total += w
+		p.current[e] += w
+		if best == nil || p.current[e] > p.current[best] {
+			best = e
+		}
+	}
+	p.current[best] -= total
+	return best
+}
+
+// LeastOutstanding is the Policy that picks the backend with the fewest outstanding requests.
+// Ties are broken at random.
+type LeastOutstanding struct{}
+
+// Pick implements the Policy interface.
+func (LeastOutstanding) Pick(req *http.Request, endpoints []*Endpoint) *Endpoint {
+	var best *Endpoint
+	ties := 0
+	for _, e := range endpoints {
+		switch {
+		case best == nil || e.Outstanding() < best.Outstanding():
+			best, ties = e, 1
+		case e.Outstanding() == best.Outstanding():
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = e
+			}
+		}
+	}
+	return best
+}
+
+// PowerOfTwo is the Policy that picks two backends at random
+// and takes the one with fewer outstanding requests.
+type PowerOfTwo struct{}
+
+// Pick implements the Policy interface.
+func (PowerOfTwo) Pick(req *http.Request, endpoints []*Endpoint) *Endpoint {
+	if len(endpoints) == 1 {
+		return endpoints[0]
+	}
+	i := rand.Intn(len(endpoints))
+	j := rand.Intn(len(endpoints) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := endpoints[i], endpoints[j]
+	if b.Outstanding() < a.Outstanding() {
+		return b
+	}
+	return a
+}
+
+// ConsistentHash is the Policy that picks the same backend for the requests with the same group key
+// by rendezvous hashing, so that adding or removing a backend moves only the keys of that backend.
+// The backends get the keys in proportion to their Weight.
+type ConsistentHash struct {
+	// GroupKeyFunc makes the key of the request.
+	// If nil, limit.GroupKeyByHost is used.
+	GroupKeyFunc func(*http.Request) string
+}
+
+func (p ConsistentHash) groupKey(req *http.Request) string {
+	if p.GroupKeyFunc == nil {
+		return limit.GroupKeyByHost(req)
+	}
+	return p.GroupKeyFunc(req)
+}
+
+// Pick implements the Policy interface.
+func (p ConsistentHash) Pick(req *http.Request, endpoints []*Endpoint) *Endpoint {
+	key := p.groupKey(req)
+	var best *Endpoint
+	bestScore := math.Inf(-1)
+	for _, e := range endpoints {
+		if s := score(key, e); s > bestScore {
+			best, bestScore = e, s
+		}
+	}
+	return best
+}
+
+// score is the weighted rendezvous hashing score of the backend for the key.
+func score(key string, e *Endpoint) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(e.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	// Mix the bits as FNV does not spread similar keys well, and map the hash to (0, 1).
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	u := (float64(x>>11) + 0.5) / (1 << 53)
+	return float64(e.weight()) / -math.Log(u)
+}
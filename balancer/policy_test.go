@@ -0,0 +1,96 @@
+package balancer
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func endpoints(bs ...Backend) []*Endpoint {
+	es := make([]*Endpoint, len(bs))
+	for i, b := range bs {
+		es[i] = &Endpoint{Backend: b}
+	}
+	return es
+}
+
+func pickSequence(p Policy, es []*Endpoint, n int) string {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	names := make([]string, n)
+	for i := range names {
+		names[i] = p.Pick(req, es).Name
+	}
+	return strings.Join(names, "")
+}
+
+func TestRoundRobin(t *testing.T) {
+	es := endpoints(backends("a", "b", "c")...)
+	if s := pickSequence(&RoundRobin{}, es, 6); s != "abcabc" {
+		t.Errorf("unexpected sequence %q", s)
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	es := endpoints(
+		Backend{Name: "a", Weight: 5},
+		Backend{Name: "b", Weight: 1},
+		Backend{Name: "c", Weight: 1},
+	)
+	if s := pickSequence(&WeightedRoundRobin{}, es, 7); s != "aabacaa" {
+		t.Errorf("unexpected sequence %q", s)
+	}
+}
+
+func TestPowerOfTwo(t *testing.T) {
+	es := endpoints(backends("a", "b")...)
+	es[0].outstanding = 3
+	if s := pickSequence(PowerOfTwo{}, es, 5); s != "bbbbb" {
+		t.Errorf("the less busy backend must be picked, actual %q", s)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	p := ConsistentHash{GroupKeyFunc: func(r *http.Request) string {
+		return r.URL.Query().Get("user")
+	}}
+	es := endpoints(backends("a", "b", "c", "d")...)
+	users := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15", "16"}
+	pick := func(es []*Endpoint, user string) string {
+		req, _ := http.NewRequest("GET", "http://example.com/?user="+user, nil)
+		return p.Pick(req, es).Name
+	}
+
+	before := map[string]string{}
+	used := map[string]bool{}
+	for _, u := range users {
+		before[u] = pick(es, u)
+		used[before[u]] = true
+		if again := pick(es, u); again != before[u] {
+			t.Errorf("the same key must be sent to the same backend, %q and %q", before[u], again)
+		}
+	}
+	if len(used) < 2 {
+		t.Errorf("the keys must be spread, actual %v", used)
+	}
+
+	// Removing "d" moves only the keys of "d".
+	for _, u := range users {
+		after := pick(es[:3], u)
+		if before[u] != "d" && after != before[u] {
+			t.Errorf("the key %s must stay on %s, actual %s", u, before[u], after)
+		}
+	}
+}
+
+func TestConsistentHashByHost(t *testing.T) {
+	es := endpoints(backends("a", "b", "c", "d")...)
+	pick := func(url string) string {
+		req, _ := http.NewRequest("GET", url, nil)
+		return ConsistentHash{}.Pick(req, es).Name
+	}
+	for _, host := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		if p1, p2 := pick("http://"+host+"/x?page=1"), pick("http://"+host+"/y?page=2"); p1 != p2 {
+			t.Errorf("the requests to %s must be sent to the same backend, %q and %q", host, p1, p2)
+		}
+	}
+}
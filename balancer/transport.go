@@ -0,0 +1,178 @@
+// Package balancer provides the RoundTripper that spreads the requests across the backends.
+package balancer
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoBackends is returned by Transport without backends.
+var ErrNoBackends = errors.New("balancer: no backends")
+
+// Backend is a named RoundTripper that Transport sends requests to.
+// The Name identifies the backend, so it must be unique and not empty.
+type Backend struct {
+	Name      string
+	Transport http.RoundTripper
+	// Weight is the relative share of the requests for the weighted policies. If zero, 1 is used.
+	Weight int
+}
+
+func (b Backend) String() string {
+	return b.Name
+}
+
+func (b Backend) transport() http.RoundTripper {
+	if b.Transport == nil {
+		return http.DefaultTransport
+	}
+	return b.Transport
+}
+
+func (b Backend) weight() int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
+}
+
+// Endpoint is a Backend with the state the Policy picks it by.
+type Endpoint struct {
+	Backend
+	outstanding int64
+}
+
+// Outstanding returns the number of the requests to the backend whose response body is not closed yet.
+func (e *Endpoint) Outstanding() int64 {
+	return atomic.LoadInt64(&e.outstanding)
+}
+
+// Transport is an implementation of the RoundTripper
+// that sends each request to one of the backends picked by the Policy.
+// Backends can be added and removed while it is used.
+type Transport struct {
+	// Policy picks the backend. If nil, RoundRobin is used.
+	Policy Policy
+
+	mu        sync.RWMutex
+	endpoints []*Endpoint
+	rr        RoundRobin
+}
+
+// New creates the Transport with the backends.
+// It panics if a backend has no Name.
+func New(policy Policy, backends ...Backend) *Transport {
+	t := &Transport{Policy: policy}
+	for _, b := range backends {
+		t.Add(b)
+	}
+	return t
+}
+
+// Add adds the backend. A backend with the same name is replaced.
+// It panics if the backend has no Name.
+func (t *Transport) Add(b Backend) {
+	if b.Name == "" {
+		panic("balancer: backend must be named")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	endpoints := make([]*Endpoint, 0, len(t.endpoints)+1)
+	for _, e := range t.endpoints {
+		if e.Name != b.Name {
+			endpoints = append(endpoints, e)
+		}
+	}
+	t.endpoints = append(endpoints, &Endpoint{Backend: b})
+}
+
+// Remove removes the backend of the name and reports whether it is found.
+// The requests in flight to the backend are not canceled.
+func (t *Transport) Remove(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	endpoints := make([]*Endpoint, 0, len(t.endpoints))
+	for _, e := range t.endpoints {
+		if e.Name != name {
+			endpoints = append(endpoints, e)
+		}
+	}
+	found := len(endpoints) != len(t.endpoints)
+	t.endpoints = endpoints
+	return found
+}
+
+// Backends returns the backends.
+func (t *Transport) Backends() []Backend {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	backends := make([]Backend, len(t.endpoints))
+	for i, e := range t.endpoints {
+		backends[i] = e.Backend
+	}
+	return backends
+}
+
+func (t *Transport) snapshot() []*Endpoint {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.endpoints
+}
+
+func (t *Transport) pick(req *http.Request, endpoints []*Endpoint) *Endpoint {
+	if t.Policy == nil {
+		return t.rr.Pick(req, endpoints)
+	}
+	return t.Policy.Pick(req, endpoints)
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoints := t.snapshot()
+	if len(endpoints) == 0 {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrNoBackends
+	}
+
+	e := t.pick(req, endpoints)
+	atomic.AddInt64(&e.outstanding, 1)
+	res, err := e.transport().RoundTrip(req)
+	if err != nil || res == nil || res.Body == nil {
+		atomic.AddInt64(&e.outstanding, -1)
+		return res, err
+	}
+	res.Body = &outstandingBody{ReadCloser: res.Body, endpoint: e}
+	return res, nil
+}
+
+// CancelRequest cancels an in-flight request by closing its connection.
+func (t *Transport) CancelRequest(req *http.Request) {
+	type canceller interface {
+		CancelRequest(*http.Request)
+	}
+	for _, e := range t.snapshot() {
+		if c, ok := e.transport().(canceller); ok {
+			c.CancelRequest(req)
+		}
+	}
+}
+
+// outstandingBody counts the request as outstanding until the body is closed.
+type outstandingBody struct {
+	io.ReadCloser
+	endpoint *Endpoint
+	once     sync.Once
+}
+
+func (b *outstandingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		atomic.AddInt64(&b.endpoint.outstanding, -1)
+	})
+	return err
+}
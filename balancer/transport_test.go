@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// nameTransport responds the name without sending the request.
+func nameTransport(name string) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(strings.NewReader(name)),
+			Request:    req,
+		}, nil
+	})
+}
+
+func backends(names ...string) []Backend {
+	bs := make([]Backend, len(names))
+	for i, name := range names {
+		bs[i] = Backend{Name: name, Transport: nameTransport(name)}
+	}
+	return bs
+}
+
+func get(t *testing.T, rt http.RoundTripper, url string) string {
+	req, _ := http.NewRequest("GET", url, nil)
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	defer res.Body.Close()
+	bs, _ := ioutil.ReadAll(res.Body)
+	return string(bs)
+}
+
+func TestTransport(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("server"))
+	}))
+	defer s.Close()
+
+	b := New(nil, Backend{Name: "server"})
+	if body := get(t, b, s.URL); body != "server" {
+		t.Errorf("the request must be sent, actual %q", body)
+	}
+}
+
+func TestTransportUnnamed(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("the unnamed backend must be rejected")
+		}
+	}()
+	New(nil, Backend{Transport: nameTransport("a")})
+}
+
+func TestTransportAddRemove(t *testing.T) {
+	b := New(nil)
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if _, err := b.RoundTrip(req); !errors.Is(err, ErrNoBackends) {
+		t.Errorf("error must be ErrNoBackends, actual %v", err)
+	}
+
+	b.Add(Backend{Name: "a", Transport: nameTransport("a")})
+	b.Add(Backend{Name: "b", Transport: nameTransport("b")})
+	b.Add(Backend{Name: "a", Transport: nameTransport("a2")})
+	if n := len(b.Backends()); n != 2 {
+		t.Errorf("the backend of the same name must be replaced, actual %d", n)
+	}
+	if !b.Remove("b") || b.Remove("b") {
+		t.Error("Remove must report whether the backend is found")
+	}
+	for i := 0; i < 3; i++ {
+		if body := get(t, b, "http://example.com"); body != "a2" {
+			t.Errorf("the remaining backend must be used, actual %q", body)
+		}
+	}
+}
+
+func TestTransportOutstanding(t *testing.T) {
+	b := New(LeastOutstanding{}, backends("a", "b")...)
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	res, err := b.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, _ := ioutil.ReadAll(res.Body)
+
+	// The other backend is picked while the body is not closed.
+	for i := 0; i < 3; i++ {
+		if body := get(t, b, "http://example.com"); body == string(busy) {
+			t.Errorf("the backend with fewer outstanding requests must be picked, actual %q", body)
+		}
+	}
+	res.Body.Close()
+	res.Body.Close()
+	for _, e := range b.snapshot() {
+		if e.Outstanding() != 0 {
+			t.Errorf("outstanding requests must be 0 after closed, actual %d", e.Outstanding())
+		}
+	}
+}
@@ -54,6 +54,11 @@ func (t *Transport) CancelRequest(req *http.Request) {
 	}
 }
 
+// Unwrap returns the RoundTripper that Transport delegates requests to.
+func (t *Transport) Unwrap() http.RoundTripper {
+	return t.base()
+}
+
 func (t *Transport) base() http.RoundTripper {
 	if t.Base != nil {
 		return t.Base
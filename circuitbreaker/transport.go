@@ -0,0 +1,348 @@
+// Package circuitbreaker provides the RoundTripper that stops sending requests to a failing backend.
+package circuitbreaker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wacul/transport/internal/window"
+	"github.com/wacul/transport/limit"
+)
+
+// State is the state of a circuit.
+type State int
+
+const (
+	// Closed lets the requests through.
+	Closed State = iota
+	// Open fails the requests fast.
+	Open
+	// HalfOpen lets a few requests through to test the recovery.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+const (
+	// DefaultWindow is the default window of the failure ratio.
+	DefaultWindow = 10 * time.Second
+	// DefaultOpenTimeout is the default duration of the open state.
+	DefaultOpenTimeout = 30 * time.Second
+	// DefaultMinRequests is the default minimum number of the requests to check the failure ratio.
+	DefaultMinRequests = 10
+)
+
+// ErrOpen is matched by the OpenError with errors.Is.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError is returned without sending the request while the circuit is open.
+type OpenError struct {
+	// Key is the group key of the circuit.
+	Key string
+	// Until is when the circuit will be half-open.
+	Until time.Time
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuitbreaker: circuit for %q is open until %s", e.Key, e.Until.Format(time.RFC3339))
+}
+
+// Is reports whether the target is ErrOpen.
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// Transport is an implementation of the RoundTripper
+// that tracks the failures for each group and fails fast while the group is failing.
+// The circuit of a group opens when ConsecutiveFailures or FailureRatio is reached.
+// After OpenTimeout, it becomes half-open and lets HalfOpenRequests through,
+// then closes if all of them succeed, or opens again if any of them fails.
+type Transport struct {
+	Transport    http.RoundTripper
+	GroupKeyFunc func(r *http.Request) string
+
+	// FailureFunc check the response of the Transport and decide whether it is a failure.
+	// If nil, errors and 5xx responses are failures.
+	FailureFunc func(*http.Response, error) bool
+
+	// ConsecutiveFailures opens the circuit when the failures continue the number of times.
+	// Zero disables it.
+	ConsecutiveFailures int
+	// FailureRatio opens the circuit when the ratio of the failures in the Window reaches it,
+	// if the number of the requests in the Window is MinRequests or more.
+	// Zero disables it. If MinRequests is zero, DefaultMinRequests is used,
+	// so that a few failures after idle do not open the circuit.
+	FailureRatio float64
+	Window       time.Duration
+	MinRequests  int
+
+	// OpenTimeout is the duration of the open state. If zero, DefaultOpenTimeout is used.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of the trial requests in the half-open state.
+	// If zero, 1 is used.
+	HalfOpenRequests int
+
+	// OnStateChange is called when the state of a circuit changes.
+	OnStateChange func(key string, from, to State)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state      State
+	generation int
+	openedAt   time.Time
+
+	consecutive int
+	requests    *window.Counter
+	failures    *window.Counter
+
+	trials    int
+	successes int
+}
+
+type stateChange struct {
+	key      string
+	from, to State
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+// Unwrap returns the RoundTripper that Transport delegates requests to.
+func (t *Transport) Unwrap() http.RoundTripper {
+	return t.transport()
+}
+
+func (t *Transport) groupKey(req *http.Request) string {
+	if t.GroupKeyFunc != nil {
+		return t.GroupKeyFunc(req)
+	}
+	return limit.GroupKeyByHost(req)
+}
+
+func (t *Transport) isFailure(res *http.Response, err error) bool {
+	if t.FailureFunc != nil {
+		return t.FailureFunc(res, err)
+	}
+	return err != nil || res.StatusCode >= 500
+}
+
+func (t *Transport) openTimeout() time.Duration {
+	if t.OpenTimeout <= 0 {
+		return DefaultOpenTimeout
+	}
+	return t.OpenTimeout
+}
+
+func (t *Transport) halfOpenRequests() int {
+	if t.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return t.HalfOpenRequests
+}
+
+func (t *Transport) minRequests() int {
+	if t.MinRequests <= 0 {
+		return DefaultMinRequests
+	}
+	return t.MinRequests
+}
+
+func (t *Transport) window() time.Duration {
+	if t.Window <= 0 {
+		return DefaultWindow
+	}
+	return t.Window
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.groupKey(req)
+	generation, err := t.allow(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.transport().RoundTrip(req)
+	if req.Context().Err() != nil {
+		// Canceled by the caller: neither a success nor a failure.
+		t.release(key, generation)
+		return res, err
+	}
+	t.record(key, generation, t.isFailure(res, err), time.Now())
+	return res, err
+}
+
+// CancelRequest cancels an in-flight request by closing its connection.
+func (t *Transport) CancelRequest(req *http.Request) {
+	type canceller interface {
+		CancelRequest(*http.Request)
+	}
+	if c, ok := t.transport().(canceller); ok {
+		c.CancelRequest(req)
+	}
+}
+
+// State returns the state of the circuit for the key.
+func (t *Transport) State(key string) State {
+	t.mu.Lock()
+	c, ok := t.circuits[key]
+	if !ok {
+		t.mu.Unlock()
+		return Closed
+	}
+	changes := t.expire(key, c, time.Now())
+	s := c.state
+	t.mu.Unlock()
+	t.notify(changes)
+	return s
+}
+
+func (t *Transport) get(key string) *circuit {
+	if t.circuits == nil {
+		t.circuits = map[string]*circuit{}
+	}
+	c, ok := t.circuits[key]
+	if !ok {
+		c = &circuit{
+			requests: window.New(t.window(), 0),
+			failures: window.New(t.window(), 0),
+		}
+		t.circuits[key] = c
+	}
+	return c
+}
+
+// allow reports whether the request can be sent, and returns the generation of the circuit.
+func (t *Transport) allow(key string, now time.Time) (int, error) {
+	t.mu.Lock()
+	c := t.get(key)
+	changes := t.expire(key, c, now)
+
+	var err error
+	switch c.state {
+	case Open:
+		err = &OpenError{Key: key, Until: c.openedAt.Add(t.openTimeout())}
+	case HalfOpen:
+		if c.trials >= t.halfOpenRequests() {
+			err = &OpenError{Key: key, Until: now}
+		} else {
+			c.trials++
+		}
+	}
+	generation := c.generation
+	t.mu.Unlock()
+
+	t.notify(changes)
+	return generation, err
+}
+
+// release gives back the trial of the request that is not recorded.
+func (t *Transport) release(key string, generation int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.get(key)
+	if c.generation == generation && c.state == HalfOpen {
+		c.trials--
+	}
+}
+
+// record counts the result of the request sent in the generation.
+func (t *Transport) record(key string, generation int, failure bool, now time.Time) {
+	t.mu.Lock()
+	c := t.get(key)
+	var changes []stateChange
+	if c.generation == generation {
+		switch c.state {
+		case Closed:
+			c.requests.Add(now, 1)
+			if failure {
+				c.consecutive++
+				c.failures.Add(now, 1)
+			} else {
+				c.consecutive = 0
+			}
+			if failure && t.shouldTrip(c, now) {
+				changes = t.transition(key, c, Open, now)
+			}
+		case HalfOpen:
+			if failure {
+				changes = t.transition(key, c, Open, now)
+				break
+			}
+			c.successes++
+			if c.successes >= t.halfOpenRequests() {
+				changes = t.transition(key, c, Closed, now)
+			}
+		}
+	}
+	t.mu.Unlock()
+	t.notify(changes)
+}
+
+func (t *Transport) shouldTrip(c *circuit, now time.Time) bool {
+	if t.ConsecutiveFailures > 0 && c.consecutive >= t.ConsecutiveFailures {
+		return true
+	}
+	if t.FailureRatio > 0 {
+		requests := c.requests.Sum(now)
+		if requests >= float64(t.minRequests()) && c.failures.Sum(now)/requests >= t.FailureRatio {
+			return true
+		}
+	}
+	return false
+}
+
+// expire makes the open circuit half-open after OpenTimeout.
+func (t *Transport) expire(key string, c *circuit, now time.Time) []stateChange {
+	if c.state == Open && !now.Before(c.openedAt.Add(t.openTimeout())) {
+		return t.transition(key, c, HalfOpen, now)
+	}
+	return nil
+}
+
+func (t *Transport) transition(key string, c *circuit, to State, now time.Time) []stateChange {
+	from := c.state
+	c.state = to
+	c.generation++
+	c.trials = 0
+	c.successes = 0
+	switch to {
+	case Open:
+		c.openedAt = now
+	case Closed:
+		c.consecutive = 0
+		c.requests.Reset()
+		c.failures.Reset()
+	}
+	return []stateChange{{key: key, from: from, to: to}}
+}
+
+func (t *Transport) notify(changes []stateChange) {
+	if t.OnStateChange == nil {
+		return
+	}
+	for _, c := range changes {
+		t.OnStateChange(c.key, c.from, c.to)
+	}
+}
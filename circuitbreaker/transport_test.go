@@ -0,0 +1,168 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type breakerTestServer struct {
+	called int
+	status int
+	lock   sync.Mutex
+}
+
+func (bt *breakerTestServer) setStatus(status int) {
+	bt.lock.Lock()
+	bt.status = status
+	bt.lock.Unlock()
+}
+
+func (bt *breakerTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bt.lock.Lock()
+	bt.called++
+	status := bt.status
+	bt.lock.Unlock()
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+}
+
+func get(client *http.Client, url string) error {
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func TestConsecutiveFailures(t *testing.T) {
+	bt := &breakerTestServer{status: 500}
+	s := httptest.NewServer(bt)
+	defer s.Close()
+
+	var changes []State
+	tr := &Transport{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         50 * time.Millisecond,
+		OnStateChange: func(key string, from, to State) {
+			changes = append(changes, to)
+		},
+	}
+	client := &http.Client{Transport: tr}
+	key := s.Listener.Addr().String()
+
+	for i := 0; i < 3; i++ {
+		if err := get(client, s.URL); err != nil {
+			t.Fatalf("request %d must be sent: %s", i, err)
+		}
+	}
+	if st := tr.State(key); st != Open {
+		t.Fatalf("the circuit must be open, actual %s", st)
+	}
+
+	err := get(client, s.URL)
+	var oe *OpenError
+	if !errors.Is(err, ErrOpen) || !errors.As(err, &oe) || oe.Key != key {
+		t.Errorf("the request must fail fast with OpenError, actual %v", err)
+	}
+	if bt.called != 3 {
+		t.Errorf("called must be 3 actual %d", bt.called)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	bt.setStatus(200)
+	if err := get(client, s.URL); err != nil {
+		t.Fatalf("the trial request must be sent: %s", err)
+	}
+	if st := tr.State(key); st != Closed {
+		t.Errorf("the circuit must be closed after the successful trial, actual %s", st)
+	}
+
+	want := []State{Open, HalfOpen, Closed}
+	if len(changes) != len(want) {
+		t.Fatalf("state changes must be %v, actual %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("state changes must be %v, actual %v", want, changes)
+		}
+	}
+}
+
+func TestHalfOpenFailure(t *testing.T) {
+	bt := &breakerTestServer{status: 500}
+	s := httptest.NewServer(bt)
+	defer s.Close()
+
+	tr := &Transport{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         20 * time.Millisecond,
+	}
+	client := &http.Client{Transport: tr}
+	key := s.Listener.Addr().String()
+
+	get(client, s.URL)
+	time.Sleep(30 * time.Millisecond)
+	if st := tr.State(key); st != HalfOpen {
+		t.Fatalf("the circuit must be half-open, actual %s", st)
+	}
+	get(client, s.URL)
+	if st := tr.State(key); st != Open {
+		t.Errorf("the failed trial must open the circuit again, actual %s", st)
+	}
+}
+
+func TestFailureRatio(t *testing.T) {
+	bt := &breakerTestServer{}
+	s := httptest.NewServer(bt)
+	defer s.Close()
+
+	tr := &Transport{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		Window:       time.Minute,
+	}
+	client := &http.Client{Transport: tr}
+	key := s.Listener.Addr().String()
+
+	get(client, s.URL)
+	get(client, s.URL)
+	bt.setStatus(503)
+	get(client, s.URL)
+	if st := tr.State(key); st != Closed {
+		t.Fatalf("the circuit must be closed under MinRequests, actual %s", st)
+	}
+	get(client, s.URL)
+	if st := tr.State(key); st != Open {
+		t.Errorf("the circuit must be open at the failure ratio, actual %s", st)
+	}
+}
+
+func TestFailureRatioDefaultMinRequests(t *testing.T) {
+	bt := &breakerTestServer{status: 503}
+	s := httptest.NewServer(bt)
+	defer s.Close()
+
+	tr := &Transport{
+		FailureRatio: 0.5,
+		Window:       time.Minute,
+	}
+	client := &http.Client{Transport: tr}
+	key := s.Listener.Addr().String()
+
+	for i := 0; i < DefaultMinRequests-1; i++ {
+		get(client, s.URL)
+	}
+	if st := tr.State(key); st != Closed {
+		t.Fatalf("the circuit must be closed under DefaultMinRequests, actual %s", st)
+	}
+	get(client, s.URL)
+	if st := tr.State(key); st != Open {
+		t.Errorf("the circuit must be open at DefaultMinRequests, actual %s", st)
+	}
+}
@@ -0,0 +1,125 @@
+// Package classifier provides the composable functions that classify the result of a request,
+// for expbackoff.Transport.RetryFunc and recover.Transport.UseSpareFunc.
+package classifier
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// Func reports whether the result of a request matches.
+// It can be used as func(*http.Response, error) bool.
+type Func func(*http.Response, error) bool
+
+// And matches the result that all of the fs match.
+func And(fs ...Func) Func {
+	return func(res *http.Response, err error) bool {
+		for _, f := range fs {
+			if !f(res, err) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or matches the result that any of the fs matches.
+func Or(fs ...Func) Func {
+	return func(res *http.Response, err error) bool {
+		for _, f := range fs {
+			if f(res, err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches the result that f does not match.
+func Not(f Func) Func {
+	return func(res *http.Response, err error) bool {
+		return !f(res, err)
+	}
+}
+
+// Error matches any error.
+func Error(res *http.Response, err error) bool {
+	return err != nil
+}
+
+// Status matches the responses with any of the status codes.
+func Status(codes ...int) Func {
+	set := make(map[int]bool, len(codes))
+	for _, c := range codes {
+		set[c] = true
+	}
+	return func(res *http.Response, err error) bool {
+		return res != nil && set[res.StatusCode]
+	}
+}
+
+// StatusRange matches the responses with the status code in [min,max].
+func StatusRange(min, max int) Func {
+	return func(res *http.Response, err error) bool {
+		return res != nil && min <= res.StatusCode && res.StatusCode <= max
+	}
+}
+
+// ServerError matches the responses with 5xx status codes.
+var ServerError = StatusRange(500, 599)
+
+// TooManyRequests matches the responses with 429 status code.
+var TooManyRequests = Status(http.StatusTooManyRequests)
+
+// Temporary matches the net.Error that is temporary.
+func Temporary(res *http.Response, err error) bool {
+	var ne interface {
+		Temporary() bool
+	}
+	return errors.As(err, &ne) && ne.Temporary()
+}
+
+// Timeout matches the net.Error that is a timeout.
+func Timeout(res *http.Response, err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// ConnectionRefused matches the error that the connection is refused.
+func ConnectionRefused(res *http.Response, err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// ConnectionReset matches the error that the connection is reset by the peer.
+func ConnectionReset(res *http.Response, err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// TLSHandshake matches the errors in the TLS handshake, including the certificate verification
+// and the alerts sent by the server. The other TLS errors, like the ones in the middle of the stream, are not matched.
+func TLSHandshake(res *http.Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	var (
+		recordErr    tls.RecordHeaderError
+		verifyErr    *tls.CertificateVerificationError
+		alertErr     tls.AlertError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+		opErr        *net.OpError
+	)
+	switch {
+	case errors.As(err, &recordErr), errors.As(err, &verifyErr), errors.As(err, &alertErr),
+		errors.As(err, &authorityErr), errors.As(err, &invalidErr), errors.As(err, &hostnameErr):
+		return true
+	case errors.As(err, &opErr):
+		return opErr.Op == "remote error"
+	}
+	return false
+}
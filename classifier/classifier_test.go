@@ -0,0 +1,89 @@
+package classifier
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	f := Or(ServerError, TooManyRequests, Status(408))
+	cases := map[int]bool{200: false, 404: false, 408: true, 429: true, 500: true, 503: true}
+	for code, want := range cases {
+		if got := f(&http.Response{StatusCode: code}, nil); got != want {
+			t.Errorf("%d must match=%v", code, want)
+		}
+	}
+	if f(nil, errors.New("failed")) {
+		t.Error("status classifiers must not match errors")
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	res := &http.Response{StatusCode: 503}
+	if !And(ServerError, Not(TooManyRequests))(res, nil) {
+		t.Error("And must match when all match")
+	}
+	if And(ServerError, TooManyRequests)(res, nil) {
+		t.Error("And must not match when any does not match")
+	}
+	if Or(Error, TooManyRequests)(res, nil) {
+		t.Error("Or must not match when none matches")
+	}
+	if !Or(Error, ServerError)(res, nil) {
+		t.Error("Or must match when any matches")
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	_, err = http.Get("http://" + addr)
+	if !ConnectionRefused(nil, err) {
+		t.Errorf("must match the refused connection: %v", err)
+	}
+	if ConnectionReset(nil, err) || Timeout(nil, err) {
+		t.Errorf("must not match other errors: %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer s.Close()
+
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+	_, err := client.Get(s.URL)
+	if !Timeout(nil, err) {
+		t.Errorf("must match the timeout: %v", err)
+	}
+}
+
+func TestTLSHandshake(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	_, err := http.Get(s.URL)
+	if !TLSHandshake(nil, err) {
+		t.Errorf("must match the unknown authority: %v", err)
+	}
+	if TLSHandshake(nil, nil) {
+		t.Error("must not match nil")
+	}
+	if !TLSHandshake(nil, &net.OpError{Op: "remote error", Err: tls.AlertError(40)}) {
+		t.Error("must match the alert from the server")
+	}
+	if TLSHandshake(nil, &net.OpError{Op: "local error", Err: errors.New("tls: bad record MAC")}) {
+		t.Error("must not match the error in the middle of the stream")
+	}
+}
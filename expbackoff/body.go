@@ -0,0 +1,91 @@
+package expbackoff
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxDrainBytes limits the bytes read from the body of a discarded response
+// to reuse its connection.
+const maxDrainBytes = 64 << 10
+
+// peekedBody streams the peeked bytes and the rest of the response body.
+type peekedBody struct {
+	io.Reader
+	peek []byte
+	body io.ReadCloser
+}
+
+func (b *peekedBody) Close() error {
+	return b.body.Close()
+}
+
+// inspect lets RetryFunc check the response and returns the response to give back.
+func (t *Transport) inspect(res *http.Response, err error) (*http.Response, bool, error) {
+	if res == nil || res.Body == nil {
+		return res, t.shouldRetry(res, err), nil
+	}
+
+	if !t.StreamResponse {
+		bs, readErr := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if readErr != nil {
+			return nil, false, readErr
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(bs))
+		retry := t.shouldRetry(res, err)
+		res.Body = ioutil.NopCloser(bytes.NewReader(bs))
+		return res, retry, nil
+	}
+
+	var peek bytes.Buffer
+	if t.PeekSize > 0 {
+		_, readErr := io.CopyN(&peek, res.Body, t.PeekSize)
+		if readErr != nil && readErr != io.EOF {
+			res.Body.Close()
+			return nil, false, readErr
+		}
+	}
+	body := res.Body
+	res.Body = ioutil.NopCloser(bytes.NewReader(peek.Bytes()))
+	retry := t.shouldRetry(res, err)
+	res.Body = &peekedBody{
+		Reader: io.MultiReader(bytes.NewReader(peek.Bytes()), body),
+		peek:   peek.Bytes(),
+		body:   body,
+	}
+	return res, retry, nil
+}
+
+// discard drains and closes the body of the response that is not given back,
+// so that its connection can be reused.
+func discard(res *http.Response) {
+	if res == nil || res.Body == nil {
+		return
+	}
+	if pb, ok := res.Body.(*peekedBody); ok {
+		drain(pb.body)
+		return
+	}
+	drain(res.Body)
+}
+
+// detach replaces the streamed body of the response with the peeked bytes,
+// and discards the rest.
+func detach(res *http.Response) *http.Response {
+	if res == nil {
+		return nil
+	}
+	if pb, ok := res.Body.(*peekedBody); ok {
+		drain(pb.body)
+		res.Body = ioutil.NopCloser(bytes.NewReader(pb.peek))
+	}
+	return res
+}
+
+func drain(rc io.ReadCloser) {
+	io.CopyN(ioutil.Discard, rc, maxDrainBytes)
+	rc.Close()
+}
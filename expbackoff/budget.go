@@ -0,0 +1,102 @@
+package expbackoff
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wacul/transport/internal/window"
+)
+
+// DefaultBudgetTTL is the default window of RetryBudget.
+const DefaultBudgetTTL = 10 * time.Second
+
+// RetryBudget limits the retries to a ratio of the recent successful requests,
+// so that retries do not multiply the load on a failing backend.
+// It can be shared by the Transports, and keeps the budget for each key.
+// See https://twitter.github.io/finagle/guide/Clients.html#retries
+type RetryBudget struct {
+	// Ratio is the retries allowed per successful request in the TTL, like 0.2 for 20%.
+	Ratio float64
+	// MinRetriesPerSecond allows the retries regardless of the successful requests.
+	MinRetriesPerSecond float64
+	// TTL is the window of the successful requests and the retries.
+	// If zero, DefaultBudgetTTL is used.
+	TTL time.Duration
+	// KeyFunc groups the requests sharing a budget. If nil, the host of the URL is used.
+	KeyFunc func(*http.Request) string
+
+	mu      sync.Mutex
+	budgets map[string]*budget
+}
+
+type budget struct {
+	deposits    *window.Counter
+	withdrawals *window.Counter
+}
+
+func (b *RetryBudget) ttl() time.Duration {
+	if b.TTL <= 0 {
+		return DefaultBudgetTTL
+	}
+	return b.TTL
+}
+
+func (b *RetryBudget) key(req *http.Request) string {
+	if b.KeyFunc != nil {
+		return b.KeyFunc(req)
+	}
+	return req.URL.Host
+}
+
+func (b *RetryBudget) get(key string) *budget {
+	if b.budgets == nil {
+		b.budgets = map[string]*budget{}
+	}
+	bg, ok := b.budgets[key]
+	if !ok {
+		bg = &budget{
+			deposits:    window.New(b.ttl(), 0),
+			withdrawals: window.New(b.ttl(), 0),
+		}
+		b.budgets[key] = bg
+	}
+	return bg
+}
+
+func (b *RetryBudget) balance(bg *budget, now time.Time) float64 {
+	return b.Ratio*bg.deposits.Sum(now) +
+		b.MinRetriesPerSecond*b.ttl().Seconds() -
+		bg.withdrawals.Sum(now)
+}
+
+// deposit records a successful request.
+func (b *RetryBudget) deposit(req *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.get(b.key(req)).deposits.Add(time.Now(), 1)
+}
+
+// withdraw consumes a retry and reports whether it is allowed.
+func (b *RetryBudget) withdraw(req *http.Request) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	bg := b.get(b.key(req))
+	if b.balance(bg, now) < 1 {
+		return false
+	}
+	bg.withdrawals.Add(now, 1)
+	return true
+}
+
+// Available returns the number of the retries currently allowed for the key.
+func (b *RetryBudget) Available(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.balance(b.get(key), time.Now())
+	if n < 0 {
+		return 0
+	}
+	return int(n)
+}
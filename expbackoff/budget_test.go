@@ -0,0 +1,56 @@
+package expbackoff
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	bt := &backoffTestServer{}
+	testServer := httptest.NewServer(bt)
+	defer testServer.Close()
+
+	budget := &RetryBudget{Ratio: 0.5}
+	newClient := func() *http.Client {
+		return &http.Client{Transport: &Transport{
+			Min:         time.Millisecond,
+			Max:         time.Second,
+			Factor:      2,
+			MaxAttempts: 5,
+			RetryFunc:   non200Error,
+			Budget:      budget,
+		}}
+	}
+
+	// 4 successes deposit 2 retries shared by the transports
+	for i := 0; i < 4; i++ {
+		res, err := newClient().Get(testServer.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	key := testServer.Listener.Addr().String()
+	if n := budget.Available(key); n != 2 {
+		t.Fatalf("2 retries must be available, actual %d", n)
+	}
+
+	bt.setReturnError(true)
+	bt.called = 0
+	_, err := newClient().Get(testServer.URL)
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Errorf("error must be ErrBudgetExhausted, actual %v", err)
+	}
+	if bt.called != 3 {
+		t.Errorf("called must be 3 actual %d", bt.called)
+	}
+	if n := budget.Available(key); n != 0 {
+		t.Errorf("no retries must be available, actual %d", n)
+	}
+	if n := budget.Available("other"); n != 0 {
+		t.Errorf("other keys must have no budget, actual %d", n)
+	}
+}
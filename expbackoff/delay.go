@@ -0,0 +1,85 @@
+package expbackoff
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DelaySource tells what decided the wait before a retry.
+type DelaySource int
+
+const (
+	// DelayBackoff means the wait is computed by the backoff.
+	DelayBackoff DelaySource = iota
+	// DelayRetryAfter means the wait is given by the Retry-After header.
+	DelayRetryAfter
+	// DelayRateLimitReset means the wait is given by the X-RateLimit-Reset or RateLimit-Reset header.
+	DelayRateLimitReset
+)
+
+func (s DelaySource) String() string {
+	switch s {
+	case DelayBackoff:
+		return "backoff"
+	case DelayRetryAfter:
+		return "retry-after"
+	case DelayRateLimitReset:
+		return "ratelimit-reset"
+	default:
+		return "DelaySource(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// rateLimitResetHeaders are the headers that tell when the rate limit is reset.
+var rateLimitResetHeaders = []string{"X-RateLimit-Reset", "RateLimit-Reset"}
+
+// epochThreshold distinguishes the unix time from the delta-seconds in the rate limit reset headers.
+const epochThreshold = 1000000000
+
+// ServerDelay returns the wait that the server asks for in the 429 or 503 response.
+// It understands Retry-After (delta-seconds or HTTP-date),
+// X-RateLimit-Reset and RateLimit-Reset (delta-seconds or unix time).
+// The ok is false if the response does not ask for a wait.
+func ServerDelay(res *http.Response, now time.Time) (wait time.Duration, source DelaySource, ok bool) {
+	if res == nil {
+		return 0, DelayBackoff, false
+	}
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return 0, DelayBackoff, false
+	}
+
+	if v := strings.TrimSpace(res.Header.Get("Retry-After")); v != "" {
+		if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return nonNegative(time.Duration(secs) * time.Second), DelayRetryAfter, true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return nonNegative(t.Sub(now)), DelayRetryAfter, true
+		}
+	}
+
+	for _, h := range rateLimitResetHeaders {
+		v := strings.TrimSpace(res.Header.Get(h))
+		if v == "" {
+			continue
+		}
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+		if secs >= epochThreshold {
+			reset := time.Unix(0, int64(secs*float64(time.Second)))
+			return nonNegative(reset.Sub(now)), DelayRateLimitReset, true
+		}
+		return nonNegative(time.Duration(secs * float64(time.Second))), DelayRateLimitReset, true
+	}
+	return 0, DelayBackoff, false
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
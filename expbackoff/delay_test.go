@@ -0,0 +1,74 @@
+package expbackoff
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServerDelay(t *testing.T) {
+	now := time.Date(2017, 4, 26, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		status int
+		header string
+		value  string
+		wait   time.Duration
+		source DelaySource
+		ok     bool
+	}{
+		{429, "Retry-After", "3", 3 * time.Second, DelayRetryAfter, true},
+		{503, "Retry-After", now.Add(5 * time.Second).Format(http.TimeFormat), 5 * time.Second, DelayRetryAfter, true},
+		{503, "Retry-After", now.Add(-5 * time.Second).Format(http.TimeFormat), 0, DelayRetryAfter, true},
+		{429, "X-RateLimit-Reset", strconv.FormatInt(now.Add(7*time.Second).Unix(), 10), 7 * time.Second, DelayRateLimitReset, true},
+		{429, "RateLimit-Reset", "2", 2 * time.Second, DelayRateLimitReset, true},
+		{429, "Retry-After", "soon", 0, DelayBackoff, false},
+		{500, "Retry-After", "3", 0, DelayBackoff, false},
+	}
+	for _, c := range cases {
+		res := &http.Response{StatusCode: c.status, Header: http.Header{}}
+		res.Header.Set(c.header, c.value)
+		wait, source, ok := ServerDelay(res, now)
+		if wait != c.wait || source != c.source || ok != c.ok {
+			t.Errorf("%d %s: %s must give (%s, %s, %v), actual (%s, %s, %v)",
+				c.status, c.header, c.value, c.wait, c.source, c.ok, wait, source, ok)
+		}
+	}
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	called := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		if called == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer s.Close()
+
+	var waits []time.Duration
+	var sources []DelaySource
+	transport := &Transport{
+		Min:            time.Millisecond,
+		Max:            time.Second,
+		MaxServerDelay: 50 * time.Millisecond,
+		Factor:         2,
+		RetryFunc:      non200Error,
+		OnRetry: func(e RetryEvent) {
+			waits = append(waits, e.Wait)
+			sources = append(sources, e.Source)
+		},
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+
+	if len(waits) != 1 || waits[0] != 50*time.Millisecond || sources[0] != DelayRetryAfter {
+		t.Errorf("Retry-After must be capped by MaxServerDelay, actual %v %v", waits, sources)
+	}
+}
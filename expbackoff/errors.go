@@ -0,0 +1,83 @@
+package expbackoff
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/wacul/transport/internal/replay"
+)
+
+var (
+	// ErrMaxAttempts is the reason of the ExhaustedError when MaxAttempts is reached.
+	ErrMaxAttempts = errors.New("max attempts reached")
+	// ErrMaxElapsedTime is the reason of the ExhaustedError when MaxElapsedTime is reached.
+	ErrMaxElapsedTime = errors.New("max elapsed time reached")
+	// ErrBodyNotReplayable is the reason of the ExhaustedError
+	// when the request body is too large to be sent again.
+	ErrBodyNotReplayable = replay.ErrNotReplayable
+
+	// ErrBudgetExhausted is the reason of the ExhaustedError when the RetryBudget suppresses the retry.
+	ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+	// ErrMaxWait is the reason given to OnGiveUp when the wait reaches Max.
+	// The last response is returned as it is.
+	ErrMaxWait = errors.New("max wait reached")
+	// ErrNotIdempotent is the reason given to OnGiveUp when Idempotency prevents retrying.
+	// The last response is returned as it is.
+	ErrNotIdempotent = errors.New("request is not idempotent")
+
+	// ErrAttemptTimeout is the error of the attempt stopped by PerAttemptTimeout.
+	// It is a net.Error whose Timeout reports true.
+	ErrAttemptTimeout error = attemptTimeoutError{}
+)
+
+type attemptTimeoutError struct{}
+
+func (attemptTimeoutError) Error() string   { return "attempt timed out" }
+func (attemptTimeoutError) Timeout() bool   { return true }
+func (attemptTimeoutError) Temporary() bool { return true }
+
+// ExhaustedError is returned by Transport when it gives up retrying.
+// It holds the response and the error of the last attempt.
+// errors.Is reports whether the Reason matches,
+// and errors.Unwrap returns the error of the last attempt.
+type ExhaustedError struct {
+	// Attempts is the number of the attempts made.
+	Attempts int
+	// Reason tells why Transport gave up, like ErrMaxAttempts.
+	// It is context.DeadlineExceeded when the next retry would start after the deadline of the request.
+	Reason error
+	// Response is the response of the last attempt, if any.
+	// Its Body has been read into memory, so it can be read without closing.
+	// With StreamResponse, the Body holds only the peeked bytes.
+	Response *http.Response
+	// Err is the error of the last attempt, if any.
+	Err error
+}
+
+func exhausted(attempts int, reason error, res *http.Response, err error) *ExhaustedError {
+	return &ExhaustedError{Attempts: attempts, Reason: reason, Response: detach(res), Err: err}
+}
+
+func (e *ExhaustedError) Error() string {
+	msg := fmt.Sprintf("expbackoff: gave up after %d attempts: %s", e.Attempts, e.Reason)
+	switch {
+	case e.Err != nil:
+		return msg + ": " + e.Err.Error()
+	case e.Response != nil:
+		return msg + ": " + e.Response.Status
+	default:
+		return msg
+	}
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *ExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is the Reason.
+func (e *ExhaustedError) Is(target error) bool {
+	return target == e.Reason
+}
@@ -0,0 +1,36 @@
+package expbackoff
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultAttemptsHeader is the conventional name for Transport.AttemptsHeader.
+const DefaultAttemptsHeader = "X-Retry-Attempts"
+
+// RetryEvent describes a retry for OnRetry, or giving up for OnGiveUp.
+type RetryEvent struct {
+	// Attempt is the number of the attempts made so far.
+	Attempt int
+	// Wait is the wait before the next attempt, and Source tells what decided it.
+	// They are zero when giving up.
+	Wait   time.Duration
+	Source DelaySource
+	// Response and Err are the result of the last attempt.
+	Response *http.Response
+	Err      error
+	// Reason tells why Transport gives up, like ErrMaxAttempts.
+	Reason error
+}
+
+type attemptsKey struct{}
+
+// Attempts returns the number of the attempts that Transport made to get the response,
+// or zero if the response is not given by Transport.
+func Attempts(res *http.Response) int {
+	if res == nil || res.Request == nil {
+		return 0
+	}
+	n, _ := res.Request.Context().Value(attemptsKey{}).(int)
+	return n
+}
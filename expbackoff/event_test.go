@@ -0,0 +1,110 @@
+package expbackoff
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransportEvents(t *testing.T) {
+	called := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		if called < 3 {
+			w.WriteHeader(503)
+		}
+	}))
+	defer testServer.Close()
+
+	var retries []RetryEvent
+	transport := &Transport{
+		Min:            time.Millisecond,
+		Max:            time.Second,
+		Factor:         2,
+		RetryFunc:      non200Error,
+		AttemptsHeader: DefaultAttemptsHeader,
+		OnRetry: func(e RetryEvent) {
+			retries = append(retries, e)
+		},
+		OnGiveUp: func(e RetryEvent) {
+			t.Errorf("OnGiveUp must not be called: %+v", e)
+		},
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+
+	if len(retries) != 2 {
+		t.Fatalf("OnRetry must be called twice, actual %d", len(retries))
+	}
+	for i, e := range retries {
+		if e.Attempt != i+1 || e.Response.StatusCode != 503 || e.Wait <= 0 || e.Source != DelayBackoff {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+	if n := Attempts(res); n != 3 {
+		t.Errorf("Attempts must be 3, actual %d", n)
+	}
+	if h := res.Header.Get(DefaultAttemptsHeader); h != "3" {
+		t.Errorf("%s must be 3, actual %q", DefaultAttemptsHeader, h)
+	}
+}
+
+func TestTransportAttemptsWithPerAttemptTimeout(t *testing.T) {
+	called := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		if called < 2 {
+			w.WriteHeader(503)
+		}
+	}))
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:               time.Millisecond,
+		Max:               time.Second,
+		Factor:            2,
+		RetryFunc:         non200Error,
+		PerAttemptTimeout: time.Second,
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+
+	if n := Attempts(res); n != 2 {
+		t.Errorf("Attempts must be 2, actual %d", n)
+	}
+}
+
+func TestTransportOnGiveUp(t *testing.T) {
+	bt := &backoffTestServer{}
+	testServer := httptest.NewServer(bt)
+	defer testServer.Close()
+
+	var gaveUp []RetryEvent
+	transport := &Transport{
+		Min:         time.Millisecond,
+		Max:         time.Second,
+		Factor:      2,
+		MaxAttempts: 2,
+		RetryFunc:   non200Error,
+		OnGiveUp: func(e RetryEvent) {
+			gaveUp = append(gaveUp, e)
+		},
+	}
+	client := &http.Client{Transport: transport}
+
+	bt.setReturnError(true)
+	client.Get(testServer.URL)
+
+	if len(gaveUp) != 1 || gaveUp[0].Attempt != 2 || gaveUp[0].Reason != ErrMaxAttempts {
+		t.Errorf("OnGiveUp must be called with ErrMaxAttempts, actual %+v", gaveUp)
+	}
+}
@@ -0,0 +1,73 @@
+package expbackoff
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"github.com/wacul/transport/internal/idempotency"
+)
+
+// DefaultIdempotencyKeyHeader is the default name of the header that carries the idempotency key.
+const DefaultIdempotencyKeyHeader = idempotency.DefaultKeyHeader
+
+// Idempotency decides whether a request is safe to retry.
+// The requests with the idempotent methods (GET, HEAD, OPTIONS, PUT and DELETE) can be retried,
+// and the others only when they have the idempotency key header.
+type Idempotency struct {
+	// HeaderName is the name of the idempotency key header.
+	// If empty, DefaultIdempotencyKeyHeader is used.
+	HeaderName string
+
+	// AutoKeyFunc selects the requests that get a generated key before the first attempt
+	// if they have no key.
+	AutoKeyFunc func(*http.Request) bool
+
+	// KeyFunc generates the key. If nil, a random UUID is generated.
+	KeyFunc func() (string, error)
+}
+
+func (i *Idempotency) headerName() string {
+	if i.HeaderName == "" {
+		return DefaultIdempotencyKeyHeader
+	}
+	return i.HeaderName
+}
+
+// Retryable reports whether the request is safe to retry.
+func (i *Idempotency) Retryable(req *http.Request) bool {
+	return idempotency.Safe(req, i.HeaderName)
+}
+
+// prepare returns the request with a generated key if AutoKeyFunc selects it.
+// The request of the caller is not modified.
+func (i *Idempotency) prepare(req *http.Request) (*http.Request, error) {
+	if i.AutoKeyFunc == nil || i.Retryable(req) || !i.AutoKeyFunc(req) {
+		return req, nil
+	}
+	key, err := i.newKey()
+	if err != nil {
+		return nil, err
+	}
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, s := range req.Header {
+		r.Header[k] = append([]string(nil), s...)
+	}
+	r.Header.Set(i.headerName(), key)
+	return r, nil
+}
+
+func (i *Idempotency) newKey() (string, error) {
+	if i.KeyFunc != nil {
+		return i.KeyFunc()
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
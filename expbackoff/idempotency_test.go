@@ -0,0 +1,75 @@
+package expbackoff
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyRetryable(t *testing.T) {
+	i := &Idempotency{}
+	cases := []struct {
+		method string
+		key    string
+		want   bool
+	}{
+		{"GET", "", true},
+		{"PUT", "", true},
+		{"DELETE", "", true},
+		{"POST", "", false},
+		{"PATCH", "", false},
+		{"POST", "abc", true},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, "http://example.com", nil)
+		if c.key != "" {
+			req.Header.Set(DefaultIdempotencyKeyHeader, c.key)
+		}
+		if got := i.Retryable(req); got != c.want {
+			t.Errorf("%s with key %q must be retryable=%v", c.method, c.key, c.want)
+		}
+	}
+}
+
+func TestTransportIdempotency(t *testing.T) {
+	var keys []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(DefaultIdempotencyKeyHeader))
+		w.WriteHeader(500)
+	}))
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:         time.Millisecond,
+		Max:         time.Second,
+		Factor:      2,
+		MaxAttempts: 3,
+		RetryFunc:   non200Error,
+		Idempotency: &Idempotency{
+			AutoKeyFunc: func(r *http.Request) bool {
+				return strings.HasPrefix(r.URL.Path, "/orders")
+			},
+		},
+	}
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Post(testServer.URL+"/payments", "text/plain", strings.NewReader("pay"))
+	if err != nil || res.StatusCode != 500 {
+		t.Fatalf("POST without the key must not be retried, actual %v %v", res, err)
+	}
+	if len(keys) != 1 || keys[0] != "" {
+		t.Errorf("POST without the key must be sent once, actual %q", keys)
+	}
+
+	keys = nil
+	req, _ := http.NewRequest("POST", testServer.URL+"/orders", strings.NewReader("order"))
+	client.Do(req)
+	if len(keys) != 3 || keys[0] == "" || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Errorf("POST to the route must be retried with the same generated key, actual %q", keys)
+	}
+	if req.Header.Get(DefaultIdempotencyKeyHeader) != "" {
+		t.Error("the request of the caller must not be modified")
+	}
+}
@@ -0,0 +1,168 @@
+package expbackoff
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BackoffPolicy computes the wait before a retry.
+type BackoffPolicy interface {
+	// Next returns the wait before the retry-th retry, counted from 1.
+	// prev is the wait before the previous retry, or zero for the first retry.
+	Next(retry int, prev time.Duration) time.Duration
+}
+
+// Rand is a source of random numbers for the policies that is safe for concurrent use.
+// Seeding it makes the sequence of the waits deterministic.
+type Rand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// NewRand creates the Rand seeded with the seed.
+func NewRand(seed int64) *Rand {
+	return &Rand{r: rand.New(rand.NewSource(seed))}
+}
+
+// float64 returns a number in [0.0,1.0).
+// The nil Rand uses the default source of math/rand.
+func (r *Rand) float64() float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+// between returns a duration in [min,max).
+func (r *Rand) between(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(r.float64()*float64(max-min))
+}
+
+// Constant waits for the same duration on every retry.
+type Constant struct {
+	Wait time.Duration
+}
+
+// Next implements BackoffPolicy.
+func (p Constant) Next(retry int, prev time.Duration) time.Duration {
+	return p.Wait
+}
+
+// Linear waits for Initial, then increases the wait by Step on every retry.
+type Linear struct {
+	Initial time.Duration
+	Step    time.Duration
+}
+
+// Next implements BackoffPolicy.
+func (p Linear) Next(retry int, prev time.Duration) time.Duration {
+	return p.Initial + p.Step*time.Duration(retry-1)
+}
+
+// Exponential waits for Initial, then multiplies the wait by Factor on every retry.
+// The wait is randomized in the range of ±RandomizeFactor (at most 1) of itself.
+type Exponential struct {
+	Initial         time.Duration
+	Factor          float64
+	RandomizeFactor float64
+	Rand            *Rand
+}
+
+// Next implements BackoffPolicy.
+func (p Exponential) Next(retry int, prev time.Duration) time.Duration {
+	w := float64(p.Initial) * math.Pow(p.Factor, float64(retry-1))
+	if p.RandomizeFactor > 0 {
+		f := math.Min(p.RandomizeFactor, 1)
+		w *= (p.Rand.float64()-0.5)*2*f + 1
+	}
+	return durationOf(w)
+}
+
+// FullJitter waits for a random duration between zero and the exponential backoff
+// from Base capped by Cap.
+// See https://www.awsarchitectureblog.com/2015/03/backoff.html
+type FullJitter struct {
+	Base time.Duration
+	Cap  time.Duration
+	Rand *Rand
+}
+
+// Next implements BackoffPolicy.
+func (p FullJitter) Next(retry int, prev time.Duration) time.Duration {
+	return p.Rand.between(0, cappedExponential(p.Base, p.Cap, retry))
+}
+
+// EqualJitter waits for a half of the exponential backoff from Base capped by Cap,
+// plus a random duration up to the other half.
+// See https://www.awsarchitectureblog.com/2015/03/backoff.html
+type EqualJitter struct {
+	Base time.Duration
+	Cap  time.Duration
+	Rand *Rand
+}
+
+// Next implements BackoffPolicy.
+func (p EqualJitter) Next(retry int, prev time.Duration) time.Duration {
+	half := cappedExponential(p.Base, p.Cap, retry) / 2
+	return half + p.Rand.between(0, half)
+}
+
+// DecorrelatedJitter waits for a random duration between Base and three times the previous wait,
+// capped by Cap.
+// See https://www.awsarchitectureblog.com/2015/03/backoff.html
+type DecorrelatedJitter struct {
+	Base time.Duration
+	Cap  time.Duration
+	Rand *Rand
+}
+
+// Next implements BackoffPolicy.
+func (p DecorrelatedJitter) Next(retry int, prev time.Duration) time.Duration {
+	if prev < p.Base {
+		prev = p.Base
+	}
+	w := p.Rand.between(p.Base, prev*3)
+	if p.Cap > 0 && w > p.Cap {
+		w = p.Cap
+	}
+	return w
+}
+
+// Fibonacci waits for Initial multiplied by the Fibonacci numbers 1, 1, 2, 3, 5, ...
+type Fibonacci struct {
+	Initial time.Duration
+}
+
+// Next implements BackoffPolicy.
+func (p Fibonacci) Next(retry int, prev time.Duration) time.Duration {
+	a, b := 1.0, 1.0
+	for i := 1; i < retry; i++ {
+		a, b = b, a+b
+	}
+	return durationOf(float64(p.Initial) * a)
+}
+
+// cappedExponential returns base * 2^(retry-1) capped by ceiling.
+// A ceiling of zero means no cap.
+func cappedExponential(base, ceiling time.Duration, retry int) time.Duration {
+	w := durationOf(float64(base) * math.Pow(2, float64(retry-1)))
+	if ceiling > 0 && w > ceiling {
+		return ceiling
+	}
+	return w
+}
+
+// durationOf converts f to the duration without overflow.
+func durationOf(f float64) time.Duration {
+	if f >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(f)
+}
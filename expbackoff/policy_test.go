@@ -0,0 +1,102 @@
+package expbackoff
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func waits(p BackoffPolicy, n int) []time.Duration {
+	var ws []time.Duration
+	var prev time.Duration
+	for i := 1; i <= n; i++ {
+		prev = p.Next(i, prev)
+		ws = append(ws, prev)
+	}
+	return ws
+}
+
+func TestDeterministicPolicies(t *testing.T) {
+	ms := time.Millisecond
+	cases := []struct {
+		policy BackoffPolicy
+		want   []time.Duration
+	}{
+		{Constant{Wait: 10 * ms}, []time.Duration{10 * ms, 10 * ms, 10 * ms, 10 * ms, 10 * ms}},
+		{Linear{Initial: 10 * ms, Step: 5 * ms}, []time.Duration{10 * ms, 15 * ms, 20 * ms, 25 * ms, 30 * ms}},
+		{Exponential{Initial: 10 * ms, Factor: 2}, []time.Duration{10 * ms, 20 * ms, 40 * ms, 80 * ms, 160 * ms}},
+		{Fibonacci{Initial: 10 * ms}, []time.Duration{10 * ms, 10 * ms, 20 * ms, 30 * ms, 50 * ms}},
+	}
+	for _, c := range cases {
+		if got := waits(c.policy, 5); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%T must wait %v, actual %v", c.policy, c.want, got)
+		}
+	}
+}
+
+func TestJitterPolicies(t *testing.T) {
+	base, ceiling := 10*time.Millisecond, 100*time.Millisecond
+	newPolicies := func() []BackoffPolicy {
+		return []BackoffPolicy{
+			FullJitter{Base: base, Cap: ceiling, Rand: NewRand(1)},
+			EqualJitter{Base: base, Cap: ceiling, Rand: NewRand(1)},
+			DecorrelatedJitter{Base: base, Cap: ceiling, Rand: NewRand(1)},
+			Exponential{Initial: base, Factor: 2, RandomizeFactor: 0.5, Rand: NewRand(1)},
+		}
+	}
+
+	first, second := newPolicies(), newPolicies()
+	for i := range first {
+		a, b := waits(first[i], 10), waits(second[i], 10)
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("%T must wait the same with the same seed, actual %v and %v", first[i], a, b)
+		}
+	}
+
+	for i, w := range waits(FullJitter{Base: base, Cap: ceiling, Rand: NewRand(2)}, 10) {
+		if w < 0 || w > cappedExponential(base, ceiling, i+1) {
+			t.Errorf("FullJitter wait %s is out of range", w)
+		}
+	}
+	for i, w := range waits(EqualJitter{Base: base, Cap: ceiling, Rand: NewRand(2)}, 10) {
+		if e := cappedExponential(base, ceiling, i+1); w < e/2 || w > e {
+			t.Errorf("EqualJitter wait %s is out of range", w)
+		}
+	}
+	for _, w := range waits(DecorrelatedJitter{Base: base, Cap: ceiling, Rand: NewRand(2)}, 10) {
+		if w < base || w > ceiling {
+			t.Errorf("DecorrelatedJitter wait %s is out of range", w)
+		}
+	}
+}
+
+func TestTransportBackoffPolicy(t *testing.T) {
+	bt := &backoffTestServer{}
+	testServer := httptest.NewServer(bt)
+	defer testServer.Close()
+
+	var got []time.Duration
+	transport := &Transport{
+		Max:         15 * time.Millisecond,
+		MaxAttempts: 4,
+		Backoff:     Linear{Initial: 5 * time.Millisecond, Step: 5 * time.Millisecond},
+		RetryFunc:   non200Error,
+		OnRetry: func(e RetryEvent) {
+			got = append(got, e.Wait)
+		},
+	}
+	client := &http.Client{Transport: transport}
+
+	bt.setReturnError(true)
+	client.Get(testServer.URL)
+
+	want := []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 15 * time.Millisecond}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("waits must be %v, actual %v", want, got)
+	}
+	if bt.called != 4 {
+		t.Errorf("called must be 4 actual %d", bt.called)
+	}
+}
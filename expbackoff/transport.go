@@ -1,25 +1,90 @@
 package expbackoff
 
 import (
-	"bytes"
 	"context"
-	"io/ioutil"
+	"errors"
 	"math/rand"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
+
+	"github.com/wacul/transport/internal/replay"
 )
 
 // Transport is an implementation of the RoundTripper that retries a request
-// with decreasing the rate on exponential backoff
+// with decreasing the rate on exponential backoff.
+// The wait starts from Min and is multiplied by Factor on each retry.
+// Retries stop once the wait reaches Max, and the last response is returned as it is.
+// If Max is zero with MaxAttempts or MaxElapsedTime, the wait is not capped and they stop retries.
 type Transport struct {
 	Transport       http.RoundTripper
 	Min             time.Duration
 	Max             time.Duration
 	RandomizeFactor float64
 
+	// MaxAttempts limits the number of the attempts including the first one.
+	// When it is reached, ExhaustedError is returned. Zero means no limit.
+	MaxAttempts int
+	// MaxElapsedTime limits the time from the start of the first attempt
+	// to the start of the last one. When the next retry would start after it,
+	// ExhaustedError is returned. Zero means no limit.
+	MaxElapsedTime time.Duration
+
+	// Backoff computes the waits instead of Min, Factor and RandomizeFactor.
+	// Then Max caps each wait instead of stopping retries,
+	// so MaxAttempts or MaxElapsedTime is required.
+	Backoff BackoffPolicy
+
 	// RetryFunc check the response of the Transport and decide whether to retry.
 	RetryFunc func(*http.Response, error) bool
 	Factor    float64
+
+	// Budget limits the retries across the requests and the Transports sharing it.
+	// If nil, the retries are not limited by others.
+	Budget *RetryBudget
+
+	// Idempotency prevents retrying the requests that are not safe to send twice, like POST.
+	// If nil, any request can be retried.
+	Idempotency *Idempotency
+
+	// MaxServerDelay caps the wait that the server asks for with
+	// the Retry-After or the rate limit reset headers (see ServerDelay).
+	// If zero, Max is used, and no cap if both are zero.
+	MaxServerDelay time.Duration
+
+	// MaxBodyMemory limits the size of the request body buffered in memory to retry
+	// when the request has no GetBody. If zero, 1MiB is used. If negative, the size is not limited.
+	MaxBodyMemory int64
+	// MaxBodySpool limits the size of the request body spooled to a temporary file in SpoolDir
+	// when it exceeds MaxBodyMemory. If zero, the body is not spooled to a file.
+	// The larger body is sent once and not retried (see ErrBodyNotReplayable).
+	MaxBodySpool int64
+	SpoolDir     string
+
+	// PerAttemptTimeout limits the time of each attempt, including reading the body for RetryFunc.
+	// The attempt that times out is retried while the context of the request is alive.
+	// The response given back is not limited by it, so a streamed body can be read longer.
+	// The error of the attempt that times out is ErrAttemptTimeout.
+	// Zero means no limit.
+	PerAttemptTimeout time.Duration
+
+	// StreamResponse stops reading the whole response body into memory for RetryFunc.
+	// RetryFunc gets the response whose Body holds only the first PeekSize bytes,
+	// and the response given back streams the body from the beginning.
+	// The responses of the failed attempts are drained and closed.
+	StreamResponse bool
+	PeekSize       int64
+
+	// OnRetry is called before sleeping for a retry, with the wait and what decided it.
+	// The Response of the event is discarded after OnRetry returns.
+	OnRetry func(RetryEvent)
+	// OnGiveUp is called when the request should be retried but Transport gives up.
+	OnGiveUp func(RetryEvent)
+
+	// AttemptsHeader is the name of the header set to the number of the attempts in the response.
+	// If empty, the header is not set. See also Attempts.
+	AttemptsHeader string
 }
 
 func (t *Transport) base() http.RoundTripper {
@@ -29,6 +94,11 @@ func (t *Transport) base() http.RoundTripper {
 	return t.Transport
 }
 
+// Unwrap returns the RoundTripper that Transport delegates requests to.
+func (t *Transport) Unwrap() http.RoundTripper {
+	return t.base()
+}
+
 func (t *Transport) shouldRetry(res *http.Response, err error) bool {
 	if t.RetryFunc == nil {
 		return err != nil
@@ -36,53 +106,244 @@ func (t *Transport) shouldRetry(res *http.Response, err error) bool {
 	return t.RetryFunc(res, err)
 }
 
+// Validate reports the configuration that makes no sense,
+// like the one that never stops retrying.
+func (t *Transport) Validate() error {
+	switch {
+	case t.Min < 0 || t.Max < 0 || t.MaxServerDelay < 0:
+		return errors.New("expbackoff: durations must not be negative")
+	case t.MaxAttempts < 0 || t.MaxElapsedTime < 0:
+		return errors.New("expbackoff: MaxAttempts and MaxElapsedTime must not be negative")
+	case t.RandomizeFactor < 0:
+		return errors.New("expbackoff: RandomizeFactor must not be negative")
+	}
+	if t.Backoff != nil {
+		if t.MaxAttempts == 0 && t.MaxElapsedTime == 0 {
+			return errors.New("expbackoff: MaxAttempts or MaxElapsedTime is required with Backoff")
+		}
+		return nil
+	}
+	if t.Max > 0 && t.Min > t.Max {
+		return errors.New("expbackoff: Min must not be greater than Max")
+	}
+	// The wait must grow to Max unless it starts there, which sends a request once.
+	if t.Max > 0 && t.Min < t.Max && t.MaxAttempts == 0 && t.MaxElapsedTime == 0 {
+		if t.Min == 0 {
+			return errors.New("expbackoff: Min must be positive unless MaxAttempts or MaxElapsedTime is set")
+		}
+		if t.Factor <= 1 {
+			return errors.New("expbackoff: Factor must be greater than 1 unless MaxAttempts or MaxElapsedTime is set")
+		}
+	}
+	return nil
+}
+
 // RoundTrip implements the RoundTripper interface.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	var reqBytes []byte
-	hasReqBody := req.Body != nil
-	if hasReqBody {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	if t.Idempotency != nil {
 		var err error
-		reqBytes, err = ioutil.ReadAll(req.Body)
-		if err != nil {
+		if req, err = t.Idempotency.prepare(req); err != nil {
 			return nil, err
 		}
-		defer req.Body.Close()
 	}
 
+	body, err := replay.New(req, replay.Options{
+		MaxMemory: t.MaxBodyMemory,
+		MaxFile:   t.MaxBodySpool,
+		Dir:       t.SpoolDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	start := time.Now()
 	current := t.Min
-	for {
-		// Copy the body for response readers.
-		req.Body = ioutil.NopCloser(bytes.NewBuffer(reqBytes))
-		res, err := t.base().RoundTrip(req)
-		var resBytes []byte
-
-		var retry bool
-		if res != nil && res.Body != nil {
-			bs, readErr := ioutil.ReadAll(res.Body)
-			if readErr != nil {
-				return nil, readErr
+	var prev time.Duration
+	for attempt := 1; ; attempt++ {
+		r, err := t.attempt(req, body, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		if !r.retry {
+			if t.Budget != nil {
+				t.Budget.deposit(req)
 			}
-			resBytes = bs
-			res.Body = ioutil.NopCloser(bytes.NewBuffer(resBytes))
-			retry = t.shouldRetry(res, err)
-			res.Body = ioutil.NopCloser(bytes.NewBuffer(resBytes))
-		} else {
-			retry = t.shouldRetry(res, err)
+			return t.response(r), r.err
+		}
+		if t.Idempotency != nil && !t.Idempotency.Retryable(req) {
+			t.notifyGiveUp(r, ErrNotIdempotent)
+			return t.response(r), r.err
+		}
+		if t.Backoff == nil && t.capsWait() && current >= t.Max {
+			t.notifyGiveUp(r, ErrMaxWait)
+			return t.response(r), r.err
+		}
+		if t.MaxAttempts > 0 && attempt >= t.MaxAttempts {
+			return nil, t.giveUp(r, ErrMaxAttempts)
+		}
+		if !body.Replayable() {
+			return nil, t.giveUp(r, ErrBodyNotReplayable)
 		}
 
-		if !retry || current >= t.Max {
-			return res, err
+		backoff := t.backoff(attempt, current, prev)
+		wait, source := t.wait(r.res, backoff)
+		if t.MaxElapsedTime > 0 && time.Since(start)+wait > t.MaxElapsedTime {
+			return nil, t.giveUp(r, ErrMaxElapsedTime)
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return nil, t.giveUp(r, context.DeadlineExceeded)
+		}
+		if t.Budget != nil && !t.Budget.withdraw(req) {
+			return nil, t.giveUp(r, ErrBudgetExhausted)
 		}
+		if t.OnRetry != nil {
+			t.OnRetry(RetryEvent{Attempt: attempt, Wait: wait, Source: source, Response: r.res, Err: r.err})
+		}
+		r.discard()
 
 		select {
 		case <-req.Context().Done():
 			return nil, context.Canceled
-		case <-time.After(current):
+		case <-time.After(wait):
 		}
+		prev = backoff
 		current = t.nextWait(current)
 	}
 }
 
+// capsWait reports whether the wait reaching Max stops retries.
+func (t *Transport) capsWait() bool {
+	return t.Max > 0 || (t.MaxAttempts == 0 && t.MaxElapsedTime == 0)
+}
+
+// attempt sends the request once and lets RetryFunc check the result.
+// The error is returned only when the request cannot be retried at all.
+func (t *Transport) attempt(req *http.Request, body *replay.Body, attempt int) (*attemptResult, error) {
+	areq, err := body.Request(req)
+	if err != nil {
+		return nil, err
+	}
+	areq = areq.WithContext(context.WithValue(areq.Context(), attemptsKey{}, attempt))
+
+	// The deadline of the attempt is a timer, not a context deadline,
+	// so that it can be stopped once the response is accepted
+	// and does not limit reading the body given back.
+	ctx, cancelCause := context.WithCancelCause(areq.Context())
+	cancel := func() { cancelCause(nil) }
+	areq = areq.WithContext(ctx)
+	var fired int32
+	stop := func() bool { return true }
+	if t.PerAttemptTimeout > 0 {
+		timer := time.AfterFunc(t.PerAttemptTimeout, func() {
+			atomic.StoreInt32(&fired, 1)
+			cancelCause(ErrAttemptTimeout)
+		})
+		stop = timer.Stop
+	}
+	timedOut := func() bool {
+		return atomic.LoadInt32(&fired) == 1 && req.Context().Err() == nil
+	}
+
+	res, err := t.base().RoundTrip(areq)
+	if err != nil && timedOut() {
+		err = ErrAttemptTimeout
+	}
+	res, retry, readErr := t.inspect(res, err)
+	if !stop() && req.Context().Err() == nil {
+		atomic.StoreInt32(&fired, 1)
+	}
+	if readErr != nil && !timedOut() {
+		cancel()
+		return nil, readErr
+	}
+	if timedOut() {
+		discard(res)
+		res, err, retry = nil, ErrAttemptTimeout, true
+	}
+	return &attemptResult{attempt: attempt, res: res, err: err, retry: retry, cancel: cancel}, nil
+}
+
+// attemptResult is the result of an attempt.
+// It must be given back, given up or discarded to release the context of the attempt.
+type attemptResult struct {
+	attempt int
+	res     *http.Response
+	err     error
+	retry   bool
+	cancel  context.CancelFunc
+}
+
+// response returns the response given back,
+// whose body releases the context of the attempt when it is closed.
+func (t *Transport) response(r *attemptResult) *http.Response {
+	if r.res == nil {
+		r.cancel()
+		return nil
+	}
+	if t.AttemptsHeader != "" {
+		r.res.Header.Set(t.AttemptsHeader, strconv.Itoa(r.attempt))
+	}
+	return replay.OnClose(r.res, r.cancel)
+}
+
+// giveUp releases the attempt and returns the ExhaustedError.
+func (t *Transport) giveUp(r *attemptResult, reason error) error {
+	err := exhausted(r.attempt, reason, r.res, r.err)
+	r.cancel()
+	if t.OnGiveUp != nil {
+		t.OnGiveUp(RetryEvent{Attempt: r.attempt, Response: err.Response, Err: r.err, Reason: reason})
+	}
+	return err
+}
+
+// notifyGiveUp calls OnGiveUp for the attempt whose result is given back as it is.
+func (t *Transport) notifyGiveUp(r *attemptResult, reason error) {
+	if t.OnGiveUp != nil {
+		t.OnGiveUp(RetryEvent{Attempt: r.attempt, Response: r.res, Err: r.err, Reason: reason})
+	}
+}
+
+// discard releases the attempt that is retried.
+func (r *attemptResult) discard() {
+	discard(r.res)
+	r.cancel()
+}
+
+// backoff returns the wait computed by Backoff, or current if Backoff is nil.
+func (t *Transport) backoff(retry int, current, prev time.Duration) time.Duration {
+	if t.Backoff == nil {
+		return current
+	}
+	w := t.Backoff.Next(retry, prev)
+	if t.Max > 0 && w > t.Max {
+		return t.Max
+	}
+	return w
+}
+
+// wait returns the wait before the next retry.
+// The wait the server asks for takes precedence over the backoff.
+func (t *Transport) wait(res *http.Response, backoff time.Duration) (time.Duration, DelaySource) {
+	d, source, ok := ServerDelay(res, time.Now())
+	if !ok {
+		return backoff, DelayBackoff
+	}
+	ceiling := t.MaxServerDelay
+	if ceiling <= 0 {
+		ceiling = t.Max
+	}
+	if ceiling > 0 && d > ceiling {
+		d = ceiling
+	}
+	return d, source
+}
+
 func (t *Transport) nextWait(current time.Duration) time.Duration {
 	r := 1.0
 	if t.RandomizeFactor > 0 {
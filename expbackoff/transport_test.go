@@ -1,9 +1,13 @@
 package expbackoff
 
 import (
+	"errors"
+	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -281,3 +285,336 @@ func TestTransportCancelWithCTXHTTP(t *testing.T) {
 		t.Errorf("It should be called twice, but actual %d", bt.called)
 	}
 }
+
+func TestTransportMaxAttempts(t *testing.T) {
+	bt := &backoffTestServer{}
+	testServer := httptest.NewServer(bt)
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:         time.Millisecond,
+		Max:         time.Second,
+		Factor:      1,
+		MaxAttempts: 3,
+		RetryFunc:   non200Error,
+	}
+	client := &http.Client{Transport: transport}
+
+	bt.setReturnError(true)
+	res, err := client.Get(testServer.URL)
+	if res != nil {
+		t.Error("response must be nil")
+	}
+
+	var ee *ExhaustedError
+	if !errors.As(err, &ee) {
+		t.Fatalf("error must be ExhaustedError, actual %v", err)
+	}
+	if !errors.Is(err, ErrMaxAttempts) {
+		t.Errorf("reason must be ErrMaxAttempts, actual %v", ee.Reason)
+	}
+	if ee.Attempts != 3 || ee.Response == nil || ee.Response.StatusCode != 500 {
+		t.Errorf("error must hold the last response and the attempts, actual %d %v", ee.Attempts, ee.Response)
+	}
+	if bt.called != 3 {
+		t.Errorf("called must be 3 actual %d", bt.called)
+	}
+}
+
+func TestTransportMaxAttemptsWithoutMax(t *testing.T) {
+	bt := &backoffTestServer{}
+	testServer := httptest.NewServer(bt)
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:         time.Millisecond,
+		Factor:      2,
+		MaxAttempts: 5,
+		RetryFunc:   non200Error,
+	}
+	client := &http.Client{Transport: transport}
+
+	bt.setReturnError(true)
+	_, err := client.Get(testServer.URL)
+	if !errors.Is(err, ErrMaxAttempts) {
+		t.Errorf("reason must be ErrMaxAttempts, actual %v", err)
+	}
+	if bt.called != 5 {
+		t.Errorf("zero Max must not stop retries, called must be 5 actual %d", bt.called)
+	}
+}
+
+func TestTransportMinEqualsMax(t *testing.T) {
+	bt := &backoffTestServer{}
+	testServer := httptest.NewServer(bt)
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:       time.Second,
+		Max:       time.Second,
+		RetryFunc: non200Error,
+	}
+	client := &http.Client{Transport: transport}
+
+	bt.setReturnError(true)
+	res, err := client.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("the response must be returned as it is, actual %d", res.StatusCode)
+	}
+	if bt.called != 1 {
+		t.Errorf("Min equal to Max must not retry, called must be 1 actual %d", bt.called)
+	}
+}
+
+func TestTransportMaxElapsedTime(t *testing.T) {
+	bt := &backoffTestServer{}
+	testServer := httptest.NewServer(bt)
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:            20 * time.Millisecond,
+		Max:            time.Second,
+		Factor:         2,
+		MaxElapsedTime: 50 * time.Millisecond,
+		RetryFunc:      non200Error,
+	}
+	client := &http.Client{Transport: transport}
+
+	bt.setReturnError(true)
+	_, err := client.Get(testServer.URL)
+	if !errors.Is(err, ErrMaxElapsedTime) {
+		t.Fatalf("error must be ErrMaxElapsedTime, actual %v", err)
+	}
+	// waits 20ms and gives up before waiting 40ms more
+	if bt.called != 2 {
+		t.Errorf("called must be 2 actual %d", bt.called)
+	}
+}
+
+func TestTransportValidate(t *testing.T) {
+	invalid := []*Transport{
+		{Min: time.Millisecond, Max: time.Second, Factor: 1},
+		{Min: 0, Max: time.Second, Factor: 2},
+		{Min: time.Second, Max: time.Millisecond, Factor: 2},
+		{Min: time.Millisecond, Max: time.Second, Factor: 2, MaxAttempts: -1},
+	}
+	for i, tr := range invalid {
+		if tr.Validate() == nil {
+			t.Errorf("#%d must be invalid", i)
+		}
+	}
+
+	valid := []*Transport{
+		{},
+		{Min: time.Millisecond, Max: time.Second, Factor: 1, MaxAttempts: 5},
+		{Min: time.Millisecond, Max: time.Second, Factor: 0.5, MaxElapsedTime: time.Second},
+		{Min: time.Second, Max: time.Second},
+		{Min: time.Second, Max: time.Second, Factor: 1},
+	}
+	for i, tr := range valid {
+		if err := tr.Validate(); err != nil {
+			t.Errorf("#%d must be valid: %s", i, err)
+		}
+	}
+}
+
+func TestTransportBodyNotReplayable(t *testing.T) {
+	bt := &backoffTestServer{}
+	testServer := httptest.NewServer(bt)
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:           time.Millisecond,
+		Max:           time.Second,
+		Factor:        2,
+		MaxBodyMemory: 4,
+		RetryFunc:     non200Error,
+	}
+	client := &http.Client{Transport: transport}
+
+	bt.setReturnError(true)
+	body := ioutil.NopCloser(strings.NewReader("too large body"))
+	_, err := client.Post(testServer.URL, "text/plain", body)
+	if !errors.Is(err, ErrBodyNotReplayable) {
+		t.Fatalf("error must be ErrBodyNotReplayable, actual %v", err)
+	}
+	if bt.called != 1 {
+		t.Errorf("called must be 1 actual %d", bt.called)
+	}
+}
+
+func TestTransportStreamResponse(t *testing.T) {
+	large := strings.Repeat("x", 1<<20)
+	called := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		if called == 1 {
+			w.Write([]byte("retry please"))
+			return
+		}
+		w.Write([]byte(large))
+	}))
+	defer testServer.Close()
+
+	var peeked []string
+	transport := &Transport{
+		Min:            time.Millisecond,
+		Max:            time.Second,
+		Factor:         2,
+		StreamResponse: true,
+		PeekSize:       5,
+		RetryFunc: func(res *http.Response, err error) bool {
+			bs, _ := ioutil.ReadAll(res.Body)
+			peeked = append(peeked, string(bs))
+			return string(bs) == "retry"
+		},
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	defer res.Body.Close()
+
+	if len(peeked) != 2 || peeked[0] != "retry" || peeked[1] != "xxxxx" {
+		t.Errorf("RetryFunc must get the peeked bytes, actual %v", peeked)
+	}
+	bs, err := ioutil.ReadAll(res.Body)
+	if err != nil || string(bs) != large {
+		t.Errorf("the whole body must be streamed, actual %d bytes, %v", len(bs), err)
+	}
+}
+
+func TestTransportPerAttemptTimeout(t *testing.T) {
+	var lock sync.Mutex
+	called := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		called++
+		n := called
+		lock.Unlock()
+		if n == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}))
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:               time.Millisecond,
+		Max:               time.Second,
+		Factor:            2,
+		PerAttemptTimeout: 30 * time.Millisecond,
+		RetryFunc:         non200Error,
+	}
+	var retryErr error
+	transport.OnRetry = func(e RetryEvent) {
+		retryErr = e.Err
+	}
+	client := &http.Client{Transport: transport}
+
+	start := time.Now()
+	res, err := client.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("the hung attempt must be abandoned, but %s taken", d)
+	}
+	if called != 2 {
+		t.Errorf("called must be 2 actual %d", called)
+	}
+	var ne net.Error
+	if retryErr != ErrAttemptTimeout || !errors.As(retryErr, &ne) || !ne.Timeout() {
+		t.Errorf("the error of the attempt must be ErrAttemptTimeout, actual %v", retryErr)
+	}
+}
+
+func TestTransportPerAttemptTimeoutGivenUp(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:               time.Millisecond,
+		Max:               time.Millisecond,
+		PerAttemptTimeout: 20 * time.Millisecond,
+		RetryFunc:         non200Error,
+	}
+	client := &http.Client{Transport: transport}
+	_, err := client.Get(testServer.URL)
+	if !errors.Is(err, ErrAttemptTimeout) {
+		t.Errorf("the error must be ErrAttemptTimeout, actual %v", err)
+	}
+}
+
+func TestTransportPerAttemptTimeoutStreamResponse(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:               time.Millisecond,
+		Max:               time.Second,
+		Factor:            2,
+		PerAttemptTimeout: 30 * time.Millisecond,
+		StreamResponse:    true,
+		PeekSize:          5,
+		RetryFunc:         non200Error,
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Get(testServer.URL)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	defer res.Body.Close()
+
+	bs, err := ioutil.ReadAll(res.Body)
+	if err != nil || string(bs) != strings.Repeat("chunk", 5) {
+		t.Errorf("the accepted body must outlive PerAttemptTimeout, actual %q, %v", bs, err)
+	}
+}
+
+func TestTransportParentDeadline(t *testing.T) {
+	bt := &backoffTestServer{}
+	testServer := httptest.NewServer(bt)
+	defer testServer.Close()
+
+	transport := &Transport{
+		Min:       100 * time.Millisecond,
+		Max:       time.Second,
+		Factor:    2,
+		RetryFunc: non200Error,
+	}
+	client := &http.Client{Transport: transport}
+
+	bt.setReturnError(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", testServer.URL, nil)
+
+	start := time.Now()
+	_, err := client.Do(req.WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error must be context.DeadlineExceeded, actual %v", err)
+	}
+	if d := time.Since(start); d > 40*time.Millisecond {
+		t.Errorf("must not sleep past the deadline, but %s taken", d)
+	}
+	if bt.called != 1 {
+		t.Errorf("called must be 1 actual %d", bt.called)
+	}
+}
@@ -0,0 +1,21 @@
+// Package idempotency decides whether a request is safe to send more than once.
+package idempotency
+
+import "net/http"
+
+// DefaultKeyHeader is the default name of the header that carries the idempotency key.
+const DefaultKeyHeader = "Idempotency-Key"
+
+// Safe reports whether the request is safe to send more than once.
+// The requests with the idempotent methods (GET, HEAD, OPTIONS, PUT and DELETE) are safe,
+// and the others only when they have the key header. If keyHeader is empty, DefaultKeyHeader is used.
+func Safe(req *http.Request, keyHeader string) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if keyHeader == "" {
+		keyHeader = DefaultKeyHeader
+	}
+	return req.Header.Get(keyHeader) != ""
+}
@@ -0,0 +1,29 @@
+package idempotency
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSafe(t *testing.T) {
+	tests := []struct {
+		method, header, keyHeader string
+		expected                  bool
+	}{
+		{"GET", "", "", true},
+		{"DELETE", "", "", true},
+		{"POST", "", "", false},
+		{"POST", DefaultKeyHeader, "", true},
+		{"POST", DefaultKeyHeader, "X-Request-Id", false},
+		{"PATCH", "X-Request-Id", "X-Request-Id", true},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, "http://example.com", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, "key")
+		}
+		if s := Safe(req, test.keyHeader); s != test.expected {
+			t.Errorf("%s with %q and %q must be %v", test.method, test.header, test.keyHeader, test.expected)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package replay makes request bodies readable more than once
+// for the transports that send a request again.
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// ErrNotReplayable is returned when the request body cannot be sent again.
+var ErrNotReplayable = errors.New("request body is not replayable")
+
+// DefaultMaxMemory is the default size limit of the body buffered in memory.
+const DefaultMaxMemory = 1 << 20
+
+// Options configures how the body without GetBody is spooled.
+type Options struct {
+	// MaxMemory limits the size of the body buffered in memory.
+	// If zero, DefaultMaxMemory is used. If negative, the size is not limited.
+	MaxMemory int64
+	// MaxFile limits the size of the body spooled to a temporary file
+	// when it exceeds MaxMemory. If zero, the body is not spooled to a file.
+	MaxFile int64
+	// Dir is the directory of the temporary file. If empty, os.TempDir is used.
+	Dir string
+}
+
+func (o Options) maxMemory() int64 {
+	if o.MaxMemory == 0 {
+		return DefaultMaxMemory
+	}
+	return o.MaxMemory
+}
+
+// Body provides the body of a request for each attempt.
+// It prefers Request.GetBody, and spools the body otherwise.
+// A Body must be closed to remove its temporary file.
+type Body struct {
+	body    io.ReadCloser
+	getBody func() (io.ReadCloser, error)
+
+	mem  []byte
+	file string
+
+	replayable bool
+	used       bool
+}
+
+// New reads the body of req as far as the options allow.
+// req.Body is closed unless it is given to the first attempt.
+func New(req *http.Request, opts Options) (*Body, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return &Body{body: req.Body, replayable: true}, nil
+	}
+	if req.GetBody != nil {
+		return &Body{body: req.Body, getBody: req.GetBody, replayable: true}, nil
+	}
+
+	maxMemory := opts.maxMemory()
+	if maxMemory < 0 {
+		bs, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		return &Body{mem: bs, replayable: true}, nil
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, req.Body, maxMemory+1)
+	if err == io.EOF {
+		req.Body.Close()
+		return &Body{mem: buf.Bytes(), replayable: true}, nil
+	}
+	if err != nil {
+		req.Body.Close()
+		return nil, err
+	}
+	if opts.MaxFile <= n {
+		return &Body{body: prepended(buf.Bytes(), req.Body)}, nil
+	}
+	return spool(req.Body, buf.Bytes(), opts)
+}
+
+// spool writes head and the rest of rc to a temporary file.
+func spool(rc io.ReadCloser, head []byte, opts Options) (*Body, error) {
+	f, err := ioutil.TempFile(opts.Dir, "transport-body-")
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	b := &Body{file: f.Name()}
+	fail := func(err error) (*Body, error) {
+		rc.Close()
+		f.Close()
+		b.Close()
+		return nil, err
+	}
+
+	if _, err := f.Write(head); err != nil {
+		return fail(err)
+	}
+	_, err = io.CopyN(f, rc, opts.MaxFile-int64(len(head))+1)
+	if err != nil && err != io.EOF {
+		return fail(err)
+	}
+	if err == nil {
+		// Exceeded MaxFile: send what is spooled and the rest once.
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fail(err)
+		}
+		b.body = &multiReadCloser{
+			Reader:  io.MultiReader(f, rc),
+			closers: []io.Closer{f, rc},
+		}
+		return b, nil
+	}
+
+	rc.Close()
+	if err := f.Close(); err != nil {
+		return fail(err)
+	}
+	b.replayable = true
+	return b, nil
+}
+
+// Replayable reports whether the body can be given to more than one attempt.
+func (b *Body) Replayable() bool {
+	return b.replayable
+}
+
+// Next returns the body for the next attempt.
+// It returns ErrNotReplayable if the body is not replayable and already used.
+func (b *Body) Next() (io.ReadCloser, error) {
+	first := !b.used
+	b.used = true
+	switch {
+	case b.body != nil && first:
+		return b.body, nil
+	case !b.replayable:
+		return nil, ErrNotReplayable
+	case b.getBody != nil:
+		return b.getBody()
+	case b.file != "":
+		return os.Open(b.file)
+	case b.mem != nil:
+		return ioutil.NopCloser(bytes.NewReader(b.mem)), nil
+	default:
+		return b.body, nil
+	}
+}
+
+// GetBody returns the function suitable for Request.GetBody,
+// or nil if the body is not replayable.
+func (b *Body) GetBody() func() (io.ReadCloser, error) {
+	if !b.replayable || (b.getBody == nil && b.file == "" && b.mem == nil) {
+		return nil
+	}
+	return func() (io.ReadCloser, error) {
+		switch {
+		case b.getBody != nil:
+			return b.getBody()
+		case b.file != "":
+			return os.Open(b.file)
+		default:
+			return ioutil.NopCloser(bytes.NewReader(b.mem)), nil
+		}
+	}
+}
+
+// Close removes the temporary file.
+// The bodies returned by Next are still readable after it on Unix-like systems.
+func (b *Body) Close() error {
+	if b.file == "" {
+		return nil
+	}
+	return os.Remove(b.file)
+}
+
+func prepended(head []byte, rc io.ReadCloser) io.ReadCloser {
+	return &multiReadCloser{
+		Reader:  io.MultiReader(bytes.NewReader(head), rc),
+		closers: []io.Closer{rc},
+	}
+}
+
+type multiReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiReadCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
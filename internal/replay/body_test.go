@@ -0,0 +1,112 @@
+package replay
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, b *Body) string {
+	rc, err := b.Next()
+	if err != nil {
+		t.Fatalf("failed to get the body: %s", err)
+	}
+	defer rc.Close()
+	bs, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read the body: %s", err)
+	}
+	return string(bs)
+}
+
+func newRequest(body io.Reader) *http.Request {
+	req, _ := http.NewRequest("POST", "http://example.com", body)
+	req.GetBody = nil
+	return req
+}
+
+func TestMemory(t *testing.T) {
+	b, err := New(newRequest(strings.NewReader("hello")), Options{MaxMemory: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 2; i++ {
+		if s := readAll(t, b); s != "hello" {
+			t.Errorf("attempt %d must read the whole body, actual %q", i, s)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := New(newRequest(strings.NewReader("hello world")), Options{MaxMemory: 4, MaxFile: 100, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.Replayable() {
+		t.Fatal("the body must be replayable")
+	}
+	for i := 0; i < 2; i++ {
+		if s := readAll(t, b); s != "hello world" {
+			t.Errorf("attempt %d must read the whole body, actual %q", i, s)
+		}
+	}
+
+	b.Close()
+	if fs, _ := ioutil.ReadDir(dir); len(fs) != 0 {
+		t.Errorf("the temporary file must be removed")
+	}
+}
+
+func TestNotReplayable(t *testing.T) {
+	for _, opts := range []Options{{MaxMemory: 4}, {MaxMemory: 4, MaxFile: 8}} {
+		b, err := New(newRequest(strings.NewReader("hello world")), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Replayable() {
+			t.Errorf("the body must not be replayable with %+v", opts)
+		}
+		if s := readAll(t, b); s != "hello world" {
+			t.Errorf("the first attempt must read the whole body, actual %q", s)
+		}
+		if _, err := b.Next(); err != ErrNotReplayable {
+			t.Errorf("the second attempt must fail with ErrNotReplayable, actual %v", err)
+		}
+		b.Close()
+	}
+}
+
+func TestGetBody(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader([]byte("hello")))
+	called := 0
+	getBody := req.GetBody
+	req.GetBody = func() (io.ReadCloser, error) {
+		called++
+		return getBody()
+	}
+
+	b, err := New(req, Options{MaxMemory: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if s := readAll(t, b); s != "hello" {
+			t.Errorf("attempt %d must read the whole body, actual %q", i, s)
+		}
+	}
+	if called != 2 {
+		t.Errorf("GetBody must be called for each retry, actual %d", called)
+	}
+}
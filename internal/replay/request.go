@@ -0,0 +1,43 @@
+package replay
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Request returns a shallow copy of req with the body for the next attempt.
+func (b *Body) Request(req *http.Request) (*http.Request, error) {
+	rc, err := b.Next()
+	if err != nil {
+		return nil, err
+	}
+	r := new(http.Request)
+	*r = *req
+	r.Body = rc
+	r.GetBody = b.GetBody()
+	return r, nil
+}
+
+// OnClose makes the body of the response call fn when it is closed,
+// like to release the context of the attempt. fn is called at once if there is no body.
+func OnClose(res *http.Response, fn func()) *http.Response {
+	if res == nil || res.Body == nil {
+		fn()
+		return res
+	}
+	res.Body = &onCloseBody{ReadCloser: res.Body, fn: fn}
+	return res
+}
+
+type onCloseBody struct {
+	io.ReadCloser
+	fn   func()
+	once sync.Once
+}
+
+func (b *onCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.fn)
+	return err
+}
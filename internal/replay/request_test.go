@@ -0,0 +1,49 @@
+package replay
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequest(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com", ioutil.NopCloser(strings.NewReader("body")))
+	b, err := New(req, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i < 2; i++ {
+		r, err := b.Request(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r == req || r.GetBody == nil {
+			t.Error("the request must be a copy with GetBody")
+		}
+		if bs, _ := ioutil.ReadAll(r.Body); string(bs) != "body" {
+			t.Errorf("the body must be replayed, actual %q", bs)
+		}
+	}
+}
+
+func TestOnClose(t *testing.T) {
+	called := 0
+	res := &http.Response{Body: ioutil.NopCloser(strings.NewReader(""))}
+	OnClose(res, func() { called++ })
+	if called != 0 {
+		t.Error("fn must not be called before the body is closed")
+	}
+	res.Body.Close()
+	res.Body.Close()
+	if called != 1 {
+		t.Errorf("fn must be called once, actual %d", called)
+	}
+
+	OnClose(nil, func() { called++ })
+	if called != 2 {
+		t.Error("fn must be called at once without a body")
+	}
+}
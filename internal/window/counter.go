@@ -0,0 +1,70 @@
+// Package window provides the counter over a sliding time window.
+package window
+
+import "time"
+
+// DefaultBuckets is the number of the buckets used when zero is given to New.
+const DefaultBuckets = 10
+
+type bucket struct {
+	epoch int64
+	sum   float64
+}
+
+// Counter sums the values added in the last window.
+// The window is divided into buckets, and expires bucket by bucket.
+// Counter is not safe for concurrent use.
+type Counter struct {
+	width   time.Duration
+	buckets []bucket
+}
+
+// New creates the Counter over the window divided into n buckets.
+func New(window time.Duration, n int) *Counter {
+	if n <= 0 {
+		n = DefaultBuckets
+	}
+	width := window / time.Duration(n)
+	if width <= 0 {
+		width = 1
+	}
+	return &Counter{
+		width:   width,
+		buckets: make([]bucket, n),
+	}
+}
+
+func (c *Counter) epoch(now time.Time) int64 {
+	return now.UnixNano() / int64(c.width)
+}
+
+// Add adds v at now.
+func (c *Counter) Add(now time.Time, v float64) {
+	e := c.epoch(now)
+	b := &c.buckets[int(e%int64(len(c.buckets)))]
+	if b.epoch != e {
+		b.epoch = e
+		b.sum = 0
+	}
+	b.sum += v
+}
+
+// Sum returns the sum of the values added in the window ending at now.
+func (c *Counter) Sum(now time.Time) float64 {
+	e := c.epoch(now)
+	oldest := e - int64(len(c.buckets)) + 1
+	var sum float64
+	for _, b := range c.buckets {
+		if oldest <= b.epoch && b.epoch <= e {
+			sum += b.sum
+		}
+	}
+	return sum
+}
+
+// Reset clears the values.
+func (c *Counter) Reset() {
+	for i := range c.buckets {
+		c.buckets[i] = bucket{}
+	}
+}
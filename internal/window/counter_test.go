@@ -0,0 +1,33 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	c := New(10*time.Second, 10)
+	now := time.Unix(1000, 0)
+
+	c.Add(now, 1)
+	c.Add(now.Add(500*time.Millisecond), 2)
+	c.Add(now.Add(5*time.Second), 4)
+	if s := c.Sum(now.Add(5 * time.Second)); s != 7 {
+		t.Errorf("sum must be 7, actual %v", s)
+	}
+	if s := c.Sum(now.Add(10 * time.Second)); s != 4 {
+		t.Errorf("the first bucket must expire, actual %v", s)
+	}
+	if s := c.Sum(now.Add(20 * time.Second)); s != 0 {
+		t.Errorf("all buckets must expire, actual %v", s)
+	}
+
+	c.Add(now.Add(20*time.Second), 8)
+	if s := c.Sum(now.Add(20 * time.Second)); s != 8 {
+		t.Errorf("the reused bucket must be cleared, actual %v", s)
+	}
+	c.Reset()
+	if s := c.Sum(now.Add(20 * time.Second)); s != 0 {
+		t.Errorf("sum must be 0 after reset, actual %v", s)
+	}
+}
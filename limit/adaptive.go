@@ -0,0 +1,309 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinLimit is the default lower bound of the adaptive concurrency limit
+	DefaultMinLimit = 1
+	// DefaultMaxLimit is the default upper bound of the adaptive concurrency limit
+	DefaultMaxLimit = 1000
+
+	// minRTTSamples is the number of the samples to estimate the latency without load
+	minRTTSamples = 100
+	// longRTTFactor is the smoothing factor of the long-term average of the latencies
+	longRTTFactor = 2.0 / (minRTTSamples + 1)
+)
+
+// AdaptiveTransportFactory manages the RoundTripper
+// that cooperate with the each of themselves to limit concurrency of the requests
+// by the limit adjusted from the latencies and the failures of the requests
+type AdaptiveTransportFactory struct {
+	// Algorithm adjusts the limit. If nil, AIMD is used
+	Algorithm LimitAlgorithm
+	// InitialLimit is the limit at first. If zero, MinLimit is used
+	InitialLimit int
+	// MinLimit is the lower bound of the limit. If zero, DefaultMinLimit is used
+	MinLimit int
+	// MaxLimit is the upper bound of the limit. If zero, DefaultMaxLimit is used
+	MaxLimit int
+	// FailureFunc check the response and decide whether the request is dropped by an overloaded server.
+	// If nil, errors, 429 Too Many Requests and 503 Service Unavailable are failures
+	FailureFunc func(*http.Response, error) bool
+
+	closeCh    chan struct{}
+	channelMap map[string]*priorityChannel
+	ml         *sync.Mutex
+	limiters   *adaptiveLimiters
+	initOnce   sync.Once
+}
+
+func (f *AdaptiveTransportFactory) init() {
+	if f.closeCh == nil {
+		f.closeCh = make(chan struct{})
+	}
+	if f.ml == nil {
+		f.ml = new(sync.Mutex)
+	}
+	if f.channelMap == nil {
+		f.channelMap = map[string]*priorityChannel{}
+	}
+	if f.limiters == nil {
+		f.limiters = newAdaptiveLimiters()
+	}
+}
+
+// NewTransport generates the RoundTripper
+// that cooperate with the each of themselves to limit concurrency of the requests adaptively
+func (f *AdaptiveTransportFactory) NewTransport() *RateLimit {
+	f.initOnce.Do(f.init)
+	return &RateLimit{
+		channelStarter: getAdaptiveStarter(f.config(), f.limiters),
+		closeCh:        f.closeCh,
+		channelMap:     f.channelMap,
+		ml:             f.ml,
+	}
+}
+
+// Limit returns the current concurrency limit of the group, and whether the group has been requested
+func (f *AdaptiveTransportFactory) Limit(key string) (int, bool) {
+	f.initOnce.Do(f.init)
+	return f.limiters.limit(key)
+}
+
+// Limits returns the current concurrency limits of the groups
+func (f *AdaptiveTransportFactory) Limits() map[string]int {
+	f.initOnce.Do(f.init)
+	return f.limiters.limits()
+}
+
+func (f *AdaptiveTransportFactory) config() adaptiveConfig {
+	return adaptiveConfig{
+		algorithm:   f.Algorithm,
+		initial:     f.InitialLimit,
+		min:         f.MinLimit,
+		max:         f.MaxLimit,
+		failureFunc: f.FailureFunc,
+	}
+}
+
+// NewAdaptiveTransport generates the RoundTripper
+// that limits concurrency of the requests in the groups
+// by the limit between minLimit and maxLimit adjusted with the algorithm.
+// Use AdaptiveTransportFactory to watch the limits
+func NewAdaptiveTransport(algorithm LimitAlgorithm, minLimit, maxLimit int) *RateLimit {
+	return &RateLimit{
+		channelStarter: getAdaptiveStarter(adaptiveConfig{
+			algorithm: algorithm,
+			min:       minLimit,
+			max:       maxLimit,
+		}, newAdaptiveLimiters()),
+	}
+}
+
+type adaptiveConfig struct {
+	algorithm   LimitAlgorithm
+	initial     int
+	min         int
+	max         int
+	failureFunc func(*http.Response, error) bool
+}
+
+// adaptiveLimiter is the state of the adaptive concurrency limit of a group
+type adaptiveLimiter struct {
+	adaptiveConfig
+	mu        sync.Mutex
+	limit     float64
+	inFlight  int
+	minRTT    time.Duration
+	windowMin time.Duration
+	samples   int
+	longRTT   float64
+	released  chan struct{}
+}
+
+func newAdaptiveLimiter(c adaptiveConfig) *adaptiveLimiter {
+	if c.algorithm == nil {
+		c.algorithm = AIMD{}
+	}
+	if c.min <= 0 {
+		c.min = DefaultMinLimit
+	}
+	if c.max <= 0 {
+		c.max = DefaultMaxLimit
+	}
+	if c.max < c.min {
+		c.max = c.min
+	}
+	if c.initial <= 0 {
+		c.initial = c.min
+	}
+	l := &adaptiveLimiter{adaptiveConfig: c, released: make(chan struct{}, 1)}
+	l.limit = l.clamp(float64(c.initial))
+	return l
+}
+
+func (l *adaptiveLimiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.min), math.Min(float64(l.max), limit))
+}
+
+func (l *adaptiveLimiter) failed(res *http.Response, err error) bool {
+	if l.failureFunc != nil {
+		return l.failureFunc(res, err)
+	}
+	return err != nil || res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable
+}
+
+// current returns the limit as the number of the requests
+func (l *adaptiveLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// acquire starts a request if the requests in flight are under the limit
+func (l *adaptiveLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight >= int(l.limit) {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+// cancel finishes a request without adjusting the limit
+func (l *adaptiveLimiter) cancel() {
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+	l.notify()
+}
+
+func (l *adaptiveLimiter) notify() {
+	select {
+	case l.released <- struct{}{}:
+	default:
+	}
+}
+
+// release finishes a request and adjusts the limit by its latency
+func (l *adaptiveLimiter) release(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	if !dropped {
+		l.observe(rtt)
+	}
+	l.limit = l.clamp(l.algorithm.Update(l.limit, Sample{
+		RTT:      rtt,
+		MinRTT:   l.minRTT,
+		LongRTT:  time.Duration(l.longRTT),
+		InFlight: l.inFlight,
+		Dropped:  dropped,
+	}))
+	l.inFlight--
+	l.mu.Unlock()
+	l.notify()
+}
+
+// observe updates the estimations of the latencies
+func (l *adaptiveLimiter) observe(rtt time.Duration) {
+	if l.windowMin == 0 || rtt < l.windowMin {
+		l.windowMin = rtt
+	}
+	if l.minRTT == 0 || rtt < l.minRTT {
+		l.minRTT = rtt
+	}
+	l.samples++
+	if l.samples%minRTTSamples == 0 {
+		// Forget the old minimum so that the estimation follows the changes of the server.
+		l.minRTT, l.windowMin = l.windowMin, 0
+	}
+	if l.longRTT == 0 {
+		l.longRTT = float64(rtt)
+	} else {
+		l.longRTT += (float64(rtt) - l.longRTT) * longRTTFactor
+	}
+}
+
+// adaptiveLimiters are the limiters of the groups
+type adaptiveLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*adaptiveLimiter
+}
+
+func newAdaptiveLimiters() *adaptiveLimiters {
+	return &adaptiveLimiters{limiters: map[string]*adaptiveLimiter{}}
+}
+
+func (ls *adaptiveLimiters) add(key string, l *adaptiveLimiter) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.limiters[key] = l
+}
+
+func (ls *adaptiveLimiters) limit(key string) (int, bool) {
+	ls.mu.Lock()
+	l, ok := ls.limiters[key]
+	ls.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	return l.current(), true
+}
+
+func (ls *adaptiveLimiters) limits() map[string]int {
+	ls.mu.Lock()
+	limiters := make(map[string]*adaptiveLimiter, len(ls.limiters))
+	for key, l := range ls.limiters {
+		limiters[key] = l
+	}
+	ls.mu.Unlock()
+
+	limits := make(map[string]int, len(limiters))
+	for key, l := range limiters {
+		limits[key] = l.current()
+	}
+	return limits
+}
+
+func getAdaptiveStarter(c adaptiveConfig, limiters *adaptiveLimiters) channelStarter {
+	return func(key string, closeCh chan struct{}) *priorityChannel {
+		pc := initPriorityChannel(closeCh)
+		limiter := newAdaptiveLimiter(c)
+		limiters.add(key, limiter)
+
+		go func() {
+			for {
+				for !limiter.acquire() {
+					select {
+					case <-closeCh:
+						return
+					case <-limiter.released:
+					}
+				}
+				req, ok := nextPayload(closeCh, pc)
+				if !ok {
+					return
+				}
+				go func() {
+					start := time.Now()
+					res := req.respond()
+					if errors.Is(res.err, context.Canceled) {
+						// The requester canceled it, which tells nothing about the server.
+						limiter.cancel()
+						return
+					}
+					limiter.release(time.Since(start), limiter.failed(res.res, res.err))
+				}()
+			}
+		}()
+
+		return pc
+	}
+}
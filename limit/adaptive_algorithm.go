@@ -0,0 +1,134 @@
+package limit
+
+import (
+	"math"
+	"time"
+)
+
+// Sample is the result of a request that LimitAlgorithm adjusts the limit by
+type Sample struct {
+	// RTT is the latency of the request
+	RTT time.Duration
+	// MinRTT is the estimation of the latency without load, the minimum of the recent latencies
+	MinRTT time.Duration
+	// LongRTT is the long-term average of the latencies
+	LongRTT time.Duration
+	// InFlight is the number of the requests in flight including this one
+	InFlight int
+	// Dropped reports whether the request failed for the overload
+	Dropped bool
+}
+
+// LimitAlgorithm adjusts the concurrency limit of a group.
+// Update is called with the current limit for each finished request, one at a time for a group,
+// and returns the next limit, which is then bounded by the min and max limits
+type LimitAlgorithm interface {
+	Update(limit float64, s Sample) float64
+}
+
+// AIMD is the LimitAlgorithm that increases the limit by one while it is used well,
+// and multiplies it by BackoffRatio when a request is dropped or slower than Timeout
+type AIMD struct {
+	// BackoffRatio is multiplied to the limit on drops. If zero, 0.9 is used
+	BackoffRatio float64
+	// Timeout is the latency regarded as a drop. If zero, only the failures are drops
+	Timeout time.Duration
+}
+
+// Update implements the LimitAlgorithm interface.
+func (a AIMD) Update(limit float64, s Sample) float64 {
+	if s.Dropped || (a.Timeout > 0 && s.RTT > a.Timeout) {
+		ratio := a.BackoffRatio
+		if ratio <= 0 || ratio >= 1 {
+			ratio = 0.9
+		}
+		return limit * ratio
+	}
+	// Grow only when the limit is the bottleneck.
+	if float64(s.InFlight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// Vegas is the LimitAlgorithm like TCP Vegas
+// that estimates the requests queued in the server from RTT and MinRTT,
+// increases the limit while the queue is shorter than Alpha and decreases it while longer than Beta
+type Vegas struct {
+	// Alpha is the queue size to increase the limit below. If zero, 3 is used
+	Alpha float64
+	// Beta is the queue size to decrease the limit above. If zero, 6 is used
+	Beta float64
+}
+
+// Update implements the LimitAlgorithm interface.
+func (v Vegas) Update(limit float64, s Sample) float64 {
+	if s.Dropped {
+		return limit / 2
+	}
+	if s.MinRTT <= 0 || s.RTT <= 0 {
+		return limit
+	}
+	alpha, beta := v.Alpha, v.Beta
+	if alpha <= 0 {
+		alpha = 3
+	}
+	if beta <= 0 {
+		beta = 6
+	}
+	if beta < alpha {
+		beta = alpha
+	}
+
+	queue := math.Ceil(limit * (1 - float64(s.MinRTT)/float64(s.RTT)))
+	switch {
+	case queue < alpha:
+		return limit + 1
+	case queue > beta:
+		return limit - 1
+	default:
+		return limit
+	}
+}
+
+// Gradient is the LimitAlgorithm like the gradient algorithm of Netflix's concurrency-limits
+// that scales the limit by the ratio of LongRTT to RTT, allowing some queue
+type Gradient struct {
+	// Tolerance is the ratio of RTT to LongRTT tolerated before reducing the limit. If zero, 1.5 is used
+	Tolerance float64
+	// Smoothing is the weight of the new limit in the moving average. If zero, 0.2 is used
+	Smoothing float64
+	// QueueSize returns the number of the requests allowed to queue for the limit.
+	// If nil, the square root of the limit is used
+	QueueSize func(limit float64) float64
+}
+
+// Update implements the LimitAlgorithm interface.
+func (g Gradient) Update(limit float64, s Sample) float64 {
+	tolerance, smoothing := g.Tolerance, g.Smoothing
+	if tolerance <= 0 {
+		tolerance = 1.5
+	}
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+
+	gradient := 0.5
+	if !s.Dropped {
+		if s.LongRTT <= 0 || s.RTT <= 0 {
+			return limit
+		}
+		gradient = math.Max(0.5, math.Min(1, tolerance*float64(s.LongRTT)/float64(s.RTT)))
+	}
+	// Grow only when the limit is the bottleneck.
+	if gradient == 1 && float64(s.InFlight)*2 < limit {
+		return limit
+	}
+
+	queue := math.Sqrt(limit)
+	if g.QueueSize != nil {
+		queue = g.QueueSize(limit)
+	}
+	next := gradient*limit + queue
+	return limit*(1-smoothing) + next*smoothing
+}
@@ -0,0 +1,109 @@
+package limit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptive(t *testing.T) {
+	ct := &concurrentTest{}
+	s := httptest.NewServer(ct)
+	defer s.Close()
+
+	factory := &AdaptiveTransportFactory{
+		InitialLimit: 4,
+		MaxLimit:     6,
+	}
+	testClient := &http.Client{
+		Transport: factory.NewTransport(),
+	}
+
+	wg := sync.WaitGroup{}
+	numReq := 100
+	wg.Add(numReq)
+	for i := 0; i < numReq; i++ {
+		go func() {
+			testClient.Get(s.URL)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if ct.maxConcurrentReq > 6 {
+		t.Errorf("concurrency must be under MaxLimit, actual %d", ct.maxConcurrentReq)
+	}
+	u, _ := url.Parse(s.URL)
+	if limit, ok := factory.Limit(u.Host); !ok || limit != 6 {
+		t.Errorf("the limit must grow to MaxLimit, actual %d", limit)
+	}
+	if limits := factory.Limits(); len(limits) != 1 || limits[u.Host] != 6 {
+		t.Errorf("unexpected limits %v", limits)
+	}
+}
+
+func TestAdaptiveDropped(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	factory := &AdaptiveTransportFactory{
+		InitialLimit: 10,
+		MinLimit:     2,
+	}
+	testClient := &http.Client{
+		Transport: factory.NewTransport(),
+	}
+	for i := 0; i < 30; i++ {
+		res, err := testClient.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	u, _ := url.Parse(s.URL)
+	if limit, _ := factory.Limit(u.Host); limit != 2 {
+		t.Errorf("the limit must shrink to MinLimit, actual %d", limit)
+	}
+}
+
+func TestAIMD(t *testing.T) {
+	a := AIMD{Timeout: time.Second}
+	if l := a.Update(10, Sample{RTT: time.Millisecond, InFlight: 10}); l != 11 {
+		t.Errorf("the limit must increase, actual %f", l)
+	}
+	if l := a.Update(10, Sample{RTT: time.Millisecond, InFlight: 1}); l != 10 {
+		t.Errorf("the limit must not increase while unused, actual %f", l)
+	}
+	if l := a.Update(10, Sample{RTT: 2 * time.Second, InFlight: 10}); l != 9 {
+		t.Errorf("the limit must decrease on timeout, actual %f", l)
+	}
+}
+
+func TestVegas(t *testing.T) {
+	v := Vegas{}
+	if l := v.Update(10, Sample{RTT: 10 * time.Millisecond, MinRTT: 10 * time.Millisecond}); l != 11 {
+		t.Errorf("the limit must increase without queue, actual %f", l)
+	}
+	if l := v.Update(10, Sample{RTT: 40 * time.Millisecond, MinRTT: 10 * time.Millisecond}); l != 9 {
+		t.Errorf("the limit must decrease with long queue, actual %f", l)
+	}
+	if l := v.Update(10, Sample{Dropped: true}); l != 5 {
+		t.Errorf("the limit must be halved on drops, actual %f", l)
+	}
+}
+
+func TestGradient(t *testing.T) {
+	g := Gradient{Smoothing: 1, QueueSize: func(float64) float64 { return 0 }}
+	if l := g.Update(10, Sample{RTT: 30 * time.Millisecond, LongRTT: 10 * time.Millisecond}); l != 5 {
+		t.Errorf("the limit must be scaled by the gradient, actual %f", l)
+	}
+	if l := g.Update(10, Sample{RTT: 10 * time.Millisecond, LongRTT: 10 * time.Millisecond, InFlight: 10}); l != 10 {
+		t.Errorf("the limit must be kept within the tolerance, actual %f", l)
+	}
+}
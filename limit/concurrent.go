@@ -45,7 +45,7 @@ func NewMaxConcurrentTransport(concurrent int) *RateLimit {
 }
 
 func getConcurrentStarter(num int) channelStarter {
-	return func(closeCh chan struct{}) *priorityChannel {
+	return func(_ string, closeCh chan struct{}) *priorityChannel {
 		block := make(chan struct{}, num)
 		pc := initPriorityChannel(closeCh)
 		go func() {
@@ -53,24 +53,16 @@ func getConcurrentStarter(num int) channelStarter {
 				select {
 				case <-closeCh:
 					return
-				case iReq := <-pc.Out:
-					select {
-					case <-closeCh:
-						return
-					case block <- struct{}{}:
-						go func() {
-							req := iReq.(requestPayload)
-							res := &httpResponseResult{}
-							res.res, res.err = req.responder()
-							select {
-							case <-closeCh:
-								return
-							case req.resCh <- res:
-								<-block
-							}
-						}()
-					}
+				case block <- struct{}{}:
 				}
+				req, ok := nextPayload(closeCh, pc)
+				if !ok {
+					return
+				}
+				go func() {
+					req.respond()
+					<-block
+				}()
 			}
 		}()
 		return pc
@@ -0,0 +1,139 @@
+package limit
+
+// fairQueue is the queue of a priority level
+// that dequeues the requests of the tenants by deficit round robin in proportion to their weights
+type fairQueue struct {
+	tenants map[string]*tenantQueue
+	// active are the tenants that have requests in the round robin order
+	active []*tenantQueue
+	cur    int
+	n      int
+}
+
+type tenantQueue struct {
+	key     string
+	weight  int
+	deficit int
+	queue   []queuedPayload
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{tenants: map[string]*tenantQueue{}}
+}
+
+func (q *fairQueue) len() int {
+	return q.n
+}
+
+func (q *fairQueue) push(qp queuedPayload) {
+	tq, ok := q.tenants[qp.tenant]
+	if !ok {
+		tq = &tenantQueue{key: qp.tenant}
+		q.tenants[qp.tenant] = tq
+	}
+	tq.weight = qp.weight
+	if tq.weight <= 0 {
+		tq.weight = 1
+	}
+	if len(tq.queue) == 0 {
+		q.active = append(q.active, tq)
+	}
+	tq.queue = append(tq.queue, qp)
+	q.n++
+}
+
+// oldest returns the earliest queued request, which is the head of a tenant
+func (q *fairQueue) oldest() (queuedPayload, bool) {
+	var oldest queuedPayload
+	found := false
+	for _, tq := range q.active {
+		if !found || tq.queue[0].queued.Before(oldest.queued) {
+			oldest, found = tq.queue[0], true
+		}
+	}
+	return oldest, found
+}
+
+// pop removes the next request by deficit round robin
+func (q *fairQueue) pop() (queuedPayload, bool) {
+	if q.n == 0 {
+		return queuedPayload{}, false
+	}
+	if q.cur >= len(q.active) {
+		q.cur = 0
+	}
+	tq := q.active[q.cur]
+	if tq.deficit <= 0 {
+		tq.deficit += tq.weight
+	}
+	qp := tq.queue[0]
+	tq.queue[0] = queuedPayload{}
+	tq.queue = tq.queue[1:]
+	tq.deficit--
+	q.n--
+	switch {
+	case len(tq.queue) == 0:
+		q.deactivate(q.cur)
+	case tq.deficit <= 0:
+		q.cur++
+	}
+	return qp, true
+}
+
+// deactivate removes the tenant without requests from the round robin
+func (q *fairQueue) deactivate(i int) {
+	tq := q.active[i]
+	tq.deficit = 0
+	q.active = append(q.active[:i], q.active[i+1:]...)
+	delete(q.tenants, tq.key)
+}
+
+// removeLatest removes the latest request of the tenant with the most requests that is accepted by f
+func (q *fairQueue) removeLatest(f func(queuedPayload) bool) bool {
+	var longest *tenantQueue
+	for _, tq := range q.active {
+		if longest == nil || len(tq.queue) > len(longest.queue) {
+			longest = tq
+		}
+	}
+	if longest == nil {
+		return false
+	}
+	for i := len(longest.queue) - 1; i >= 0; i-- {
+		if f(longest.queue[i]) {
+			q.removeAt(longest, i)
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the payload, and reports false if it is not queued
+func (q *fairQueue) remove(p *requestPayload) bool {
+	for _, tq := range q.active {
+		for i, qp := range tq.queue {
+			if qp.requestPayload == p {
+				q.removeAt(tq, i)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (q *fairQueue) removeAt(tq *tenantQueue, i int) {
+	tq.queue = append(tq.queue[:i], tq.queue[i+1:]...)
+	q.n--
+	if len(tq.queue) > 0 {
+		return
+	}
+	for j, a := range q.active {
+		if a == tq {
+			q.deactivate(j)
+			if j < q.cur {
+				q.cur--
+			}
+			return
+		}
+	}
+}
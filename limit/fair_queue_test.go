@@ -0,0 +1,104 @@
+package limit
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFairQueue(t *testing.T) {
+	q := newFairQueue()
+	tenants := map[*requestPayload]string{}
+	push := func(tenant string, weight, n int) {
+		for i := 0; i < n; i++ {
+			p := newRequestPayload(nil)
+			tenants[p] = tenant
+			q.push(queuedPayload{requestPayload: p, tenant: tenant, weight: weight})
+		}
+	}
+	push("noisy", 1, 6)
+	push("quiet", 1, 2)
+	push("paid", 2, 4)
+
+	order := ""
+	for qp, ok := q.pop(); ok; qp, ok = q.pop() {
+		order += tenants[qp.requestPayload][:1]
+	}
+	if order != "nqppnqppnnnn" {
+		t.Errorf("the tenants must be dequeued by the weights, actual %q", order)
+	}
+	if q.len() != 0 || len(q.tenants) != 0 {
+		t.Errorf("the queue must be empty, actual %d", q.len())
+	}
+}
+
+func TestFairQueueRemove(t *testing.T) {
+	q := newFairQueue()
+	a1, a2, b := newRequestPayload(nil), newRequestPayload(nil), newRequestPayload(nil)
+	q.push(queuedPayload{requestPayload: a1, tenant: "a"})
+	q.push(queuedPayload{requestPayload: a2, tenant: "a"})
+	q.push(queuedPayload{requestPayload: b, tenant: "b"})
+
+	if !q.removeLatest(func(queuedPayload) bool { return true }) {
+		t.Fatal("a request must be removed")
+	}
+	if q.remove(a2) {
+		t.Error("the latest request of the busiest tenant must be removed")
+	}
+	if !q.remove(b) {
+		t.Error("the queued request must be removed")
+	}
+	if qp, _ := q.pop(); qp.requestPayload != a1 {
+		t.Error("the remaining request must be dequeued")
+	}
+}
+
+func TestRateLimitTenants(t *testing.T) {
+	var l sync.Mutex
+	var order []string
+	release := make(chan struct{})
+	transport := blockedTransport(release, func(transport *RateLimit) {
+		transport.TenantKeyFunc = func(r *http.Request) string {
+			return r.Header.Get("X-Tenant")
+		}
+		inner := transport.Transport
+		transport.Transport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if tenant := req.Header.Get("X-Tenant"); tenant != "" {
+				l.Lock()
+				order = append(order, tenant)
+				l.Unlock()
+				return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+			}
+			return inner.RoundTrip(req)
+		})
+	})
+	defer transport.Close()
+
+	pc := transport.waitCh("example.com")
+	wg := sync.WaitGroup{}
+	send := func(tenant string) {
+		wg.Add(1)
+		n := pc.Len()
+		go func() {
+			req, _ := http.NewRequest("GET", "http://example.com", nil)
+			req.Header.Set("X-Tenant", tenant)
+			transport.RoundTrip(req)
+			wg.Done()
+		}()
+		for pc.Len() == n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		send("noisy")
+	}
+	send("quiet")
+	close(release)
+	wg.Wait()
+
+	if s := strings.Join(order, ","); s != "noisy,quiet,noisy,noisy,noisy" {
+		t.Errorf("the quiet tenant must not wait for the noisy one, actual %s", s)
+	}
+}
@@ -48,7 +48,7 @@ func NewIntervalTransport(interval time.Duration) *RateLimit {
 }
 
 func getIntervalStarter(interval time.Duration) channelStarter {
-	return func(closeCh chan struct{}) *priorityChannel {
+	return func(_ string, closeCh chan struct{}) *priorityChannel {
 		pc := initPriorityChannel(closeCh)
 		tick := time.Tick(interval)
 
@@ -58,21 +58,11 @@ func getIntervalStarter(interval time.Duration) channelStarter {
 				case <-closeCh:
 					return
 				case <-tick:
-					select {
-					case <-closeCh:
+					req, ok := nextPayload(closeCh, pc)
+					if !ok {
 						return
-					case iReq := <-pc.Out:
-						go func() {
-							req := iReq.(requestPayload)
-							res := &httpResponseResult{}
-							res.res, res.err = req.responder()
-							select {
-							case <-closeCh:
-								return
-							case req.resCh <- res:
-							}
-						}()
 					}
+					go req.respond()
 				}
 			}
 		}()
@@ -0,0 +1,99 @@
+package limit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PriorityLevel is a level of the priorities of the requests
+type PriorityLevel struct {
+	// Name is the name of the level specified in the priority header or WithPriority
+	Name string
+	// Weight is the share of the requests dequeued from the level while the levels have requests, like 8, 4 and 1.
+	// If all the levels have zero weight, the requests of the higher levels are always dequeued first
+	Weight int
+}
+
+// DefaultPriorityLevels are the levels used if RateLimit has no PriorityLevels
+var DefaultPriorityLevels = []PriorityLevel{
+	{Name: "high"},
+	{Name: "normal"},
+	{Name: "low"},
+}
+
+// DefaultPriorityName is the name of the level of the requests without priority
+const DefaultPriorityName = "normal"
+
+type priorityKey struct{}
+
+// WithPriority returns the context that makes the request the priority,
+// the name of a level or its number counted from 0 for the highest level.
+// It takes precedence over the priority header
+func WithPriority(ctx context.Context, priority string) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func (t *RateLimit) priorityLevels() []PriorityLevel {
+	if len(t.PriorityLevels) == 0 {
+		return DefaultPriorityLevels
+	}
+	return t.PriorityLevels
+}
+
+// parsePriority returns the level of the name or number, and reports false if it is not found
+func (t *RateLimit) parsePriority(s string) (priority, bool) {
+	levels := t.priorityLevels()
+	if s == "" {
+		return 0, false
+	}
+	for i, l := range levels {
+		if strings.EqualFold(l.Name, s) {
+			return priority(i), true
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < len(levels) {
+		return priority(n), true
+	}
+	return 0, false
+}
+
+func (t *RateLimit) defaultPriority() priority {
+	name := t.DefaultPriority
+	if name == "" {
+		name = DefaultPriorityName
+	}
+	if pr, ok := t.parsePriority(name); ok {
+		return pr
+	}
+	return priority(len(t.priorityLevels()) - 1)
+}
+
+func (t *RateLimit) priority(r *http.Request) priority {
+	if s, ok := r.Context().Value(priorityKey{}).(string); ok {
+		if pr, ok := t.parsePriority(s); ok {
+			return pr
+		}
+	}
+	if pr, ok := t.parsePriority(r.Header.Get(t.priorityHeader())); ok {
+		return pr
+	}
+	return t.defaultPriority()
+}
+
+func (t *RateLimit) priorityWeights() []int {
+	levels := t.priorityLevels()
+	weights := make([]int, len(levels))
+	weighted := false
+	for i, l := range levels {
+		if l.Weight > 0 {
+			weights[i] = l.Weight
+			weighted = true
+		}
+	}
+	if !weighted {
+		return nil
+	}
+	return weights
+}
@@ -1,67 +1,191 @@
 package limit
 
-// priorityChannel is the channels support the priority of them.
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when the queue of the group has MaxQueueLength requests
+var ErrQueueFull = errors.New("limit: queue is full")
+
+// ErrQueueTimeout is returned when the request waits in the queue longer than MaxQueueWait
+var ErrQueueTimeout = errors.New("limit: queue wait timed out")
+
+// priority is the index of the level of the request, 0 for the highest level
+type priority int
+
+type queuedPayload struct {
+	*requestPayload
+	priority priority
+	// tenant and weight are for the fair queuing in the level
+	tenant string
+	weight int
+	queued time.Time
+}
+
+// priorityChannel is the queues of the requests in the order of their priorities.
 type priorityChannel struct {
-	Out    chan interface{}
-	High   chan interface{}
-	Normal chan interface{}
-	Low    chan interface{}
-	stopCh chan struct{}
+	mu     sync.Mutex
+	levels []*fairQueue
+	ready  chan struct{}
+
+	// weights are the weights of the levels for weighted fair dequeueing, or nil for strict priority
+	weights []int
+	current []int
+	// aging promotes the requests one level for each aging waited in strict priority
+	aging time.Duration
 }
 
 // initPriorityChannel will create the priorityChannel and initialize it
 func initPriorityChannel(closeCh <-chan struct{}) *priorityChannel {
-	pc := priorityChannel{}
-	pc.Out = make(chan interface{})
-	pc.High = make(chan interface{})
-	pc.Normal = make(chan interface{})
-	pc.Low = make(chan interface{})
-	pc.stopCh = make(chan struct{})
-
-	pc.start()
-	return &pc
+	return &priorityChannel{
+		ready: make(chan struct{}, 1),
+	}
 }
 
-// Close will close all channels in it.
-func (pc *priorityChannel) Close() {
-	close(pc.stopCh)
-	close(pc.High)
-	close(pc.Normal)
-	close(pc.Low)
-	close(pc.Out)
+// schedule configures how the levels are dequeued
+func (pc *priorityChannel) schedule(weights []int, aging time.Duration) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.weights = weights
+	pc.current = make([]int, len(weights))
+	pc.aging = aging
 }
 
-func (pc *priorityChannel) start() {
-	go func() {
-		for {
-			select {
-			case s := <-pc.High:
-				pc.Out <- s
-			case <-pc.stopCh:
-				return
-			default:
-			}
+// Len returns the number of the queued requests
+func (pc *priorityChannel) Len() int {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.len()
+}
+
+func (pc *priorityChannel) len() int {
+	n := 0
+	for _, q := range pc.levels {
+		n += q.len()
+	}
+	return n
+}
+
+// push queues the payload. If the queue has maxLength requests, the payload is rejected with ErrQueueFull,
+// or a request of the lowest level is rejected instead if shedLow is set and the payload has higher priority.
+func (pc *priorityChannel) push(qp queuedPayload, maxLength int, shedLow bool) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if maxLength > 0 && pc.len() >= maxLength {
+		if !shedLow || !pc.shedLower(qp.priority) {
+			return ErrQueueFull
+		}
+	}
+	for len(pc.levels) <= int(qp.priority) {
+		pc.levels = append(pc.levels, newFairQueue())
+	}
+	qp.queued = time.Now()
+	pc.levels[qp.priority].push(qp)
+
+	select {
+	case pc.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
 
-			select {
-			case s := <-pc.High:
-				pc.Out <- s
-			case s := <-pc.Normal:
-				pc.Out <- s
-			case <-pc.stopCh:
-				return
-			default:
+// shedLower rejects the latest request of the busiest tenant in the lowest level lower than pr,
+// and reports false if there is none
+func (pc *priorityChannel) shedLower(pr priority) bool {
+	for l := len(pc.levels) - 1; l > int(pr); l-- {
+		shed := pc.levels[l].removeLatest(func(qp queuedPayload) bool {
+			if !qp.dequeue() {
+				return false
 			}
+			qp.resCh <- &httpResponseResult{err: ErrQueueFull}
+			return true
+		})
+		if shed {
+			return true
+		}
+	}
+	return false
+}
+
+// pop removes the next request, or returns nil if there is none
+func (pc *priorityChannel) pop() *requestPayload {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	l := -1
+	if pc.weights != nil {
+		l = pc.nextWeighted()
+	} else {
+		l = pc.nextStrict(time.Now())
+	}
+	if l < 0 {
+		return nil
+	}
+	qp, _ := pc.levels[l].pop()
+	return qp.requestPayload
+}
 
-			select {
-			case s := <-pc.High:
-				pc.Out <- s
-			case s := <-pc.Normal:
-				pc.Out <- s
-			case s := <-pc.Low:
-				pc.Out <- s
-			case <-pc.stopCh:
-				return
+// nextStrict returns the highest level that has requests, regarding the aged requests as higher
+func (pc *priorityChannel) nextStrict(now time.Time) int {
+	if pc.aging <= 0 {
+		for l, q := range pc.levels {
+			if q.len() > 0 {
+				return l
 			}
 		}
-	}()
+		return -1
+	}
+
+	best, bestLevel := -1, 0
+	var bestQueued time.Time
+	for l, q := range pc.levels {
+		oldest, ok := q.oldest()
+		if !ok {
+			continue
+		}
+		level := l - int(now.Sub(oldest.queued)/pc.aging)
+		if best < 0 || level < bestLevel || (level == bestLevel && oldest.queued.Before(bestQueued)) {
+			best, bestLevel, bestQueued = l, level, oldest.queued
+		}
+	}
+	return best
+}
+
+// nextWeighted returns the level that has requests by smooth weighted round robin
+func (pc *priorityChannel) nextWeighted() int {
+	best, total := -1, 0
+	for l, q := range pc.levels {
+		if q.len() == 0 {
+			continue
+		}
+		w := 1
+		if l < len(pc.weights) && pc.weights[l] > 0 {
+			w = pc.weights[l]
+		}
+		for len(pc.current) <= l {
+			pc.current = append(pc.current, 0)
+		}
+		total += w
+		pc.current[l] += w
+		if best < 0 || pc.current[l] > pc.current[best] {
+			best = l
+		}
+	}
+	if best >= 0 {
+		pc.current[best] -= total
+	}
+	return best
+}
+
+// remove removes the payload from the queue, and reports false if it is not queued
+func (pc *priorityChannel) remove(p *requestPayload) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for _, q := range pc.levels {
+		if q.remove(p) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,68 @@
+package limit
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	testHigh priority = iota
+	testNormal
+	testLow
+)
+
+func TestPriorityChannel(t *testing.T) {
+	pc := initPriorityChannel(nil)
+	low := newRequestPayload(nil)
+	normal1 := newRequestPayload(nil)
+	normal2 := newRequestPayload(nil)
+	high := newRequestPayload(nil)
+	pc.push(queuedPayload{requestPayload: low, priority: testLow}, 0, false)
+	pc.push(queuedPayload{requestPayload: normal1, priority: testNormal}, 0, false)
+	pc.push(queuedPayload{requestPayload: normal2, priority: testNormal}, 0, false)
+	pc.push(queuedPayload{requestPayload: high, priority: testHigh}, 0, false)
+
+	if !pc.remove(normal2) || pc.remove(normal2) {
+		t.Error("remove must report whether the payload is queued")
+	}
+	for i, expected := range []*requestPayload{high, normal1, low, nil} {
+		if p := pc.pop(); p != expected {
+			t.Errorf("unexpected payload at %d", i)
+		}
+	}
+}
+
+func TestPriorityChannelWeighted(t *testing.T) {
+	pc := initPriorityChannel(nil)
+	pc.schedule([]int{4, 2, 1}, 0)
+	levels := map[*requestPayload]string{}
+	for i := 0; i < 7; i++ {
+		for pr, name := range []string{"h", "n", "l"} {
+			p := newRequestPayload(nil)
+			levels[p] = name
+			pc.push(queuedPayload{requestPayload: p, priority: priority(pr)}, 0, false)
+		}
+	}
+
+	order := ""
+	for i := 0; i < 7; i++ {
+		order += levels[pc.pop()]
+	}
+	if order != "hnhlhnh" {
+		t.Errorf("the levels must be dequeued by the weights, actual %q", order)
+	}
+}
+
+func TestPriorityChannelAging(t *testing.T) {
+	pc := initPriorityChannel(nil)
+	pc.schedule(nil, 10*time.Millisecond)
+	low := newRequestPayload(nil)
+	high := newRequestPayload(nil)
+	pc.push(queuedPayload{requestPayload: low, priority: testLow}, 0, false)
+	time.Sleep(25 * time.Millisecond)
+	pc.push(queuedPayload{requestPayload: high, priority: testHigh}, 0, false)
+
+	if p := pc.pop(); p != low {
+		t.Error("the request waiting long must be promoted")
+	}
+}
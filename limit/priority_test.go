@@ -0,0 +1,141 @@
+package limit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimitPriority(t *testing.T) {
+	transport := &RateLimit{
+		PriorityLevels: []PriorityLevel{
+			{Name: "critical"},
+			{Name: "interactive"},
+			{Name: "batch"},
+			{Name: "background"},
+		},
+		DefaultPriority: "batch",
+	}
+
+	tests := []struct {
+		header   string
+		ctx      string
+		expected priority
+	}{
+		{"", "", 2},
+		{"Interactive", "", 1},
+		{"3", "", 3},
+		{"4", "", 2},
+		{"unknown", "", 2},
+		{"critical", "background", 3},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com", nil)
+		if test.header != "" {
+			req.Header.Set(DefaultPriorityHeaderName, test.header)
+		}
+		if test.ctx != "" {
+			req = req.WithContext(WithPriority(context.Background(), test.ctx))
+		}
+		if pr := transport.priority(req); pr != test.expected {
+			t.Errorf("priority of %q and %q must be %d, actual %d", test.header, test.ctx, test.expected, pr)
+		}
+	}
+}
+
+func TestRateLimitDefaultPriority(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if pr := (&RateLimit{}).priority(req); pr != 1 {
+		t.Errorf("the requests without priority must be normal, actual %d", pr)
+	}
+	transport := &RateLimit{PriorityLevels: []PriorityLevel{{Name: "a"}, {Name: "b"}}}
+	if pr := transport.priority(req); pr != 1 {
+		t.Errorf("the requests without priority must be the lowest, actual %d", pr)
+	}
+}
+
+func TestRateLimitStripPriorityHeader(t *testing.T) {
+	var received http.Header
+	transport := NewMaxConcurrentTransport(1)
+	transport.Transport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		received = req.Header
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	})
+	defer transport.Close()
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set(DefaultPriorityHeaderName, "high")
+	req.Header.Set("X-Other", "1")
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if received.Get(DefaultPriorityHeaderName) != "" || received.Get("X-Other") != "1" {
+		t.Errorf("only the priority header must be removed, actual %v", received)
+	}
+	if req.Header.Get(DefaultPriorityHeaderName) != "high" {
+		t.Error("the request of the caller must not be modified")
+	}
+
+	transport.KeepPriorityHeader = true
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if received.Get(DefaultPriorityHeaderName) != "high" {
+		t.Error("the priority header must be kept with KeepPriorityHeader")
+	}
+}
+
+type cancelRecorder struct {
+	sent     chan *http.Request
+	canceled chan *http.Request
+}
+
+func (c *cancelRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.sent <- req
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func (c *cancelRecorder) CancelRequest(req *http.Request) {
+	c.canceled <- req
+}
+
+func TestRateLimitCancelStrippedRequest(t *testing.T) {
+	rec := &cancelRecorder{sent: make(chan *http.Request, 1), canceled: make(chan *http.Request, 1)}
+	transport := NewMaxConcurrentTransport(1)
+	transport.Transport = rec
+	defer transport.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req = req.WithContext(ctx)
+	req.Header.Set(DefaultPriorityHeaderName, "high")
+	done := make(chan struct{})
+	go func() {
+		transport.RoundTrip(req)
+		close(done)
+	}()
+	sent := <-rec.sent
+	transport.CancelRequest(req)
+	if canceled := <-rec.canceled; canceled != sent || sent == req {
+		t.Error("the request sent without the priority header must be canceled")
+	}
+	cancel()
+	<-done
+
+	// The abandoned request is forgotten when the queued send returns.
+	deadline := time.Now().Add(time.Second)
+	for {
+		transport.mu.Lock()
+		n := len(transport.modReq)
+		transport.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the request must be forgotten after the round trip, actual %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
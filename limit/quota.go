@@ -0,0 +1,131 @@
+package limit
+
+import (
+	"sync"
+	"time"
+)
+
+// Quota is the limit of the number of the requests in any sliding window
+type Quota struct {
+	Limit  int
+	Window time.Duration
+}
+
+// QuotaTransportFactory manages the RoundTripper
+// that cooperate with the each of themselves to keep the requests within the quotas
+type QuotaTransportFactory struct {
+	Quotas     []Quota
+	closeCh    chan struct{}
+	channelMap map[string]*priorityChannel
+	ml         *sync.Mutex
+	initOnce   sync.Once
+}
+
+func (f *QuotaTransportFactory) init() {
+	if f.closeCh == nil {
+		f.closeCh = make(chan struct{})
+	}
+	if f.ml == nil {
+		f.ml = new(sync.Mutex)
+	}
+	if f.channelMap == nil {
+		f.channelMap = map[string]*priorityChannel{}
+	}
+}
+
+// NewTransport generates the RoundTripper
+// that cooperate with the each of themselves to keep the requests within the quotas
+func (f *QuotaTransportFactory) NewTransport() *RateLimit {
+	f.initOnce.Do(f.init)
+	return &RateLimit{
+		channelStarter: getQuotaStarter(f.Quotas),
+		closeCh:        f.closeCh,
+		channelMap:     f.channelMap,
+		ml:             f.ml,
+	}
+}
+
+// NewQuotaTransport generates the RoundTripper
+// that keeps the requests in the groups within all the quotas, like 600 per 10 minutes and 10000 per day.
+// The requests over a quota wait until the oldest request in its window leaves the window.
+func NewQuotaTransport(quotas ...Quota) *RateLimit {
+	return &RateLimit{
+		channelStarter: getQuotaStarter(quotas),
+	}
+}
+
+// quotaLog records the start times of the latest Limit requests
+type quotaLog struct {
+	Quota
+	starts []time.Time
+	next   int
+}
+
+// wait returns the duration until a request can start within the quota
+func (l *quotaLog) wait(now time.Time) time.Duration {
+	if len(l.starts) < l.Limit {
+		return 0
+	}
+	return l.starts[l.next].Add(l.Window).Sub(now)
+}
+
+func (l *quotaLog) record(now time.Time) {
+	if len(l.starts) < l.Limit {
+		l.starts = append(l.starts, now)
+		return
+	}
+	l.starts[l.next] = now
+	l.next = (l.next + 1) % l.Limit
+}
+
+// quotaLogs are the logs of all the quotas of a group
+type quotaLogs []*quotaLog
+
+func newQuotaLogs(quotas []Quota) quotaLogs {
+	logs := make(quotaLogs, 0, len(quotas))
+	for _, q := range quotas {
+		if q.Limit > 0 && q.Window > 0 {
+			logs = append(logs, &quotaLog{Quota: q})
+		}
+	}
+	return logs
+}
+
+func (ls quotaLogs) wait(now time.Time) time.Duration {
+	var max time.Duration
+	for _, l := range ls {
+		if d := l.wait(now); d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+func (ls quotaLogs) record(now time.Time) {
+	for _, l := range ls {
+		l.record(now)
+	}
+}
+
+func getQuotaStarter(quotas []Quota) channelStarter {
+	return func(_ string, closeCh chan struct{}) *priorityChannel {
+		pc := initPriorityChannel(closeCh)
+		logs := newQuotaLogs(quotas)
+
+		go func() {
+			for {
+				if !waitUntil(closeCh, logs.wait) {
+					return
+				}
+				req, ok := nextPayload(closeCh, pc)
+				if !ok {
+					return
+				}
+				logs.record(time.Now())
+				go req.respond()
+			}
+		}()
+
+		return pc
+	}
+}
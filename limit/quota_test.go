@@ -0,0 +1,95 @@
+package limit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	ct := &countTest{}
+	s := httptest.NewServer(ct)
+	defer s.Close()
+
+	transport := NewQuotaTransport(
+		Quota{Limit: 3, Window: 100 * time.Millisecond},
+		Quota{Limit: 5, Window: 300 * time.Millisecond},
+	)
+	defer transport.Close()
+	testClient := &http.Client{
+		Transport: transport,
+	}
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	numReq := 8
+	wg.Add(numReq)
+	for i := 0; i < numReq; i++ {
+		go func() {
+			testClient.Get(s.URL)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	// 3 at 0ms, 2 at 100ms, and 3 at 300ms
+	if elapsed := time.Since(start); elapsed < 290*time.Millisecond {
+		t.Errorf("requests must wait for the longer window, but finished in %s", elapsed)
+	}
+	if n := ct.countWithin(80 * time.Millisecond); n > 3 {
+		t.Errorf("requests must be within the shorter quota, actual %d", n)
+	}
+	if n := ct.countWithin(260 * time.Millisecond); n > 5 {
+		t.Errorf("requests must be within the longer quota, actual %d", n)
+	}
+}
+
+func TestQuotaFactory(t *testing.T) {
+	ct := &countTest{}
+	s := httptest.NewServer(ct)
+	defer s.Close()
+
+	factory := QuotaTransportFactory{
+		Quotas: []Quota{{Limit: 2, Window: 100 * time.Millisecond}},
+	}
+
+	wg := sync.WaitGroup{}
+	numReq := 6
+	wg.Add(numReq)
+	for i := 0; i < numReq; i++ {
+		go func() {
+			testClient := &http.Client{
+				Transport: factory.NewTransport(),
+			}
+			testClient.Get(s.URL)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if n := ct.countWithin(80 * time.Millisecond); n > 2 {
+		t.Errorf("transports must share the quota, actual %d", n)
+	}
+}
+
+func TestQuotaLogs(t *testing.T) {
+	now := time.Now()
+	logs := newQuotaLogs([]Quota{
+		{Limit: 2, Window: time.Second},
+		{Limit: 0, Window: time.Second},
+	})
+	if len(logs) != 1 {
+		t.Fatalf("invalid quotas must be ignored, actual %d", len(logs))
+	}
+	logs.record(now)
+	logs.record(now.Add(500 * time.Millisecond))
+	if d := logs.wait(now.Add(600 * time.Millisecond)); d != 400*time.Millisecond {
+		t.Errorf("the oldest request must leave the window in 400ms, actual %s", d)
+	}
+	logs.record(now.Add(time.Second))
+	if d := logs.wait(now.Add(time.Second)); d != 500*time.Millisecond {
+		t.Errorf("the oldest request must leave the window in 500ms, actual %s", d)
+	}
+}
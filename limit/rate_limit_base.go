@@ -3,21 +3,86 @@ package limit
 import (
 	"errors"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wacul/transport/internal/replay"
 )
 
+var errRequestCanceled = errors.New("request canceled")
+
 type httpResponseResult struct {
 	res *http.Response
 	err error
 }
 
+// states of requestPayload
+const (
+	payloadQueued int32 = iota
+	payloadStarted
+	payloadAbandoned
+	payloadDone
+)
+
 type requestPayload struct {
 	responder func() (*http.Response, error)
 	resCh     chan *httpResponseResult
+	state     int32
+}
+
+func newRequestPayload(responder func() (*http.Response, error)) *requestPayload {
+	return &requestPayload{
+		responder: responder,
+		resCh:     make(chan *httpResponseResult, 1),
+	}
+}
+
+// start marks the payload started, and reports false if the requester has abandoned it
+func (p *requestPayload) start() bool {
+	return atomic.CompareAndSwapInt32(&p.state, payloadQueued, payloadStarted)
+}
+
+// dequeue marks the queued payload abandoned, and reports false if it is already started or abandoned
+func (p *requestPayload) dequeue() bool {
+	return atomic.CompareAndSwapInt32(&p.state, payloadQueued, payloadAbandoned)
 }
 
-type channelStarter func(chan struct{}) *priorityChannel
+// abandon marks the payload abandoned by the requester, and reports false if the result is already sent
+func (p *requestPayload) abandon() bool {
+	return p.dequeue() || atomic.CompareAndSwapInt32(&p.state, payloadStarted, payloadAbandoned)
+}
+
+// respond sends the request of the started payload and passes the result to the requester.
+// The response is closed if the requester has abandoned it
+func (p *requestPayload) respond() *httpResponseResult {
+	res := &httpResponseResult{}
+	res.res, res.err = p.responder()
+	p.resCh <- res
+	if !atomic.CompareAndSwapInt32(&p.state, payloadStarted, payloadDone) && res.res != nil && res.res.Body != nil {
+		res.res.Body.Close()
+	}
+	return res
+}
+
+// nextPayload receives the next payload that is not abandoned and marks it started.
+// It reports false if closeCh is closed
+func nextPayload(closeCh chan struct{}, pc *priorityChannel) (*requestPayload, bool) {
+	for {
+		for p := pc.pop(); p != nil; p = pc.pop() {
+			if p.start() {
+				return p, true
+			}
+		}
+		select {
+		case <-closeCh:
+			return nil, false
+		case <-pc.ready:
+		}
+	}
+}
+
+type channelStarter func(key string, closeCh chan struct{}) *priorityChannel
 
 // RateLimit is an implementation of the RoundTripper
 // that limits a quantity of requests in the groups
@@ -25,11 +90,41 @@ type RateLimit struct {
 	Transport          http.RoundTripper
 	GroupKeyFunc       func(r *http.Request) string
 	PriorityHeaderName string
-	channelStarter     channelStarter
-	closeCh            chan struct{}
-	channelMap         map[string]*priorityChannel
-	ml                 *sync.Mutex
-	initOnce           sync.Once
+	// KeepPriorityHeader makes the priority header sent to the server.
+	// By default it is removed from a copy of the request, as it is only for RateLimit
+	KeepPriorityHeader bool
+	// PriorityLevels are the levels of the priorities from the highest.
+	// If empty, DefaultPriorityLevels is used
+	PriorityLevels []PriorityLevel
+	// DefaultPriority is the name of the level of the requests without priority.
+	// If empty, DefaultPriorityName is used, or the lowest level if no level has the name
+	DefaultPriority string
+	// PriorityAging makes a queued request one level higher for each PriorityAging it waits,
+	// so that the lower levels are not starved. It is used only if no level has Weight
+	PriorityAging time.Duration
+	// TenantKeyFunc makes the tenant of the request. The requests of the same level in a group are dequeued
+	// fairly across the tenants by deficit round robin, so that a tenant can not take the whole limit.
+	// If nil, they are dequeued in the order of arrival
+	TenantKeyFunc func(r *http.Request) string
+	// TenantWeights are the shares of the tenants. The tenants not in it have weight 1
+	TenantWeights map[string]int
+	// MaxQueueLength limits the number of the requests waiting in the queue of a group.
+	// The requests over it fail with ErrQueueFull. If zero, the queue is not limited
+	MaxQueueLength int
+	// MaxQueueWait limits the time a request waits in the queue.
+	// The requests waiting longer fail with ErrQueueTimeout. If zero, the wait is not limited
+	MaxQueueWait time.Duration
+	// ShedLowPriority makes the latest request of the lowest level in the full queue fail with ErrQueueFull
+	// instead of a request of a higher level
+	ShedLowPriority bool
+	channelStarter  channelStarter
+	closeCh         chan struct{}
+	channelMap      map[string]*priorityChannel
+	ml              *sync.Mutex
+	initOnce        sync.Once
+
+	mu     sync.Mutex                      // guards modReq
+	modReq map[*http.Request]*http.Request // original -> outgoing
 }
 
 // ConstantGroupKeyFunc restricts whole requests in RateLimit
@@ -66,7 +161,8 @@ func (t *RateLimit) waitCh(key string) *priorityChannel {
 	if ok {
 		return ch
 	}
-	ch = t.channelStarter(t.closeCh)
+	ch = t.channelStarter(key, t.closeCh)
+	ch.schedule(t.priorityWeights(), t.PriorityAging)
 	t.channelMap[key] = ch
 	return ch
 }
@@ -84,50 +180,116 @@ func (t *RateLimit) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	key := t.distKey(req)
 	if key == "" {
-		return t.transport().RoundTrip(req)
+		return t.send(req)
+	}
+
+	ctx := req.Context()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pc := t.waitCh(key)
+	mreq := newRequestPayload(func() (*http.Response, error) {
+		return t.send(req)
+	})
+	qp := queuedPayload{requestPayload: mreq, priority: t.priority(req)}
+	if t.TenantKeyFunc != nil {
+		qp.tenant = t.TenantKeyFunc(req)
+		qp.weight = t.TenantWeights[qp.tenant]
+	}
+	if err := pc.push(qp, t.MaxQueueLength, t.ShedLowPriority); err != nil {
+		return nil, err
 	}
 
-	reqCh := t.waitCh(key)
-	resCh := make(chan *httpResponseResult)
-	mreq := requestPayload{
-		responder: func() (*http.Response, error) {
-			return t.transport().RoundTrip(req)
-		},
-		resCh: resCh,
+	var queueTimeout <-chan time.Time
+	if t.MaxQueueWait > 0 {
+		timer := time.NewTimer(t.MaxQueueWait)
+		defer timer.Stop()
+		queueTimeout = timer.C
 	}
-	select {
-	case t.channelForRequest(reqCh, req) <- mreq:
+	var err error
+	for err == nil {
 		select {
-		case mres := <-resCh:
+		case mres := <-mreq.resCh:
 			return mres.res, mres.err
+		case <-queueTimeout:
+			if mreq.dequeue() {
+				pc.remove(mreq)
+				return nil, ErrQueueTimeout
+			}
+			// The request has started.
+			queueTimeout = nil
+		case <-ctx.Done():
+			err = ctx.Err()
 		case <-t.closeCh:
-			return nil, errors.New("request canceled")
+			err = errRequestCanceled
 		}
-	case <-t.closeCh:
-		return nil, errors.New("request canceled")
 	}
+	if mreq.abandon() {
+		pc.remove(mreq)
+		return nil, err
+	}
+	mres := <-mreq.resCh
+	return mres.res, mres.err
 }
 
 // CancelRequest cancels an in-flight request by closing its connection.
+// The copy of the request sent without the priority header is canceled.
 func (t *RateLimit) CancelRequest(req *http.Request) {
 	type canceller interface {
 		CancelRequest(*http.Request)
 	}
+	t.mu.Lock()
+	if modReq, ok := t.modReq[req]; ok {
+		req = modReq
+	}
+	t.mu.Unlock()
 	if c, ok := t.transport().(canceller); ok {
 		c.CancelRequest(req)
 	}
 }
 
-func (t *RateLimit) channelForRequest(pc *priorityChannel, r *http.Request) chan<- interface{} {
-	pr := strings.ToLower(r.Header.Get(t.priorityHeader()))
-	switch pr {
-	case "high":
-		return pc.High
-	case "low":
-		return pc.Low
-	default:
-		return pc.Normal
+// send sends the outgoing request, and remembers it for CancelRequest until the response is done
+func (t *RateLimit) send(req *http.Request) (*http.Response, error) {
+	out := t.outgoing(req)
+	if out == req {
+		return t.transport().RoundTrip(req)
+	}
+	t.setModReq(req, out)
+	res, err := t.transport().RoundTrip(out)
+	return replay.OnClose(res, func() { t.setModReq(req, nil) }), err
+}
+
+func (t *RateLimit) setModReq(orig, mod *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.modReq == nil {
+		t.modReq = make(map[*http.Request]*http.Request)
+	}
+	if mod == nil {
+		delete(t.modReq, orig)
+	} else {
+		t.modReq[orig] = mod
+	}
+}
+
+// outgoing returns the request to send to the server without the priority header
+func (t *RateLimit) outgoing(req *http.Request) *http.Request {
+	if t.KeepPriorityHeader {
+		return req
+	}
+	if _, ok := req.Header[http.CanonicalHeaderKey(t.priorityHeader())]; !ok {
+		return req
 	}
+	r2 := new(http.Request)
+	*r2 = *req
+	r2.Header = req.Header.Clone()
+	r2.Header.Del(t.priorityHeader())
+	return r2
+}
+
+// Unwrap returns the RoundTripper that RateLimit delegates requests to.
+func (t *RateLimit) Unwrap() http.RoundTripper {
+	return t.transport()
 }
 
 func (t *RateLimit) transport() http.RoundTripper {
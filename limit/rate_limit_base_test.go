@@ -0,0 +1,226 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type closeRecorder struct {
+	closed int32
+}
+
+func (c *closeRecorder) Read(p []byte) (int, error) {
+	return strings.NewReader("ok").Read(p)
+}
+
+func (c *closeRecorder) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func TestRateLimitContextQueued(t *testing.T) {
+	var sent int32
+	release := make(chan struct{})
+	transport := NewMaxConcurrentTransport(1)
+	transport.Transport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&sent, 1)
+		<-release
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("ok"))}, nil
+	})
+	defer transport.Close()
+
+	done := make(chan struct{})
+	go func() {
+		req, _ := http.NewRequest("GET", "http://example.com", nil)
+		transport.RoundTrip(req)
+		close(done)
+	}()
+	for atomic.LoadInt32(&sent) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	start := time.Now()
+	_, err := transport.RoundTrip(req.WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error must be the context error, actual %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the queued request must fail soon, but %s taken", elapsed)
+	}
+
+	close(release)
+	<-done
+	req, _ = http.NewRequest("GET", "http://example.com", nil)
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&sent); n != 2 {
+		t.Errorf("the abandoned request must not be sent, actual %d requests", n)
+	}
+}
+
+func TestRateLimitContextInFlight(t *testing.T) {
+	release := make(chan struct{})
+	body := &closeRecorder{}
+	transport := NewMaxConcurrentTransport(1)
+	transport.Transport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("X-Slow") != "" {
+			// Ignore the context like a transport without cancellation.
+			<-release
+			return &http.Response{StatusCode: 200, Body: body}, nil
+		}
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("ok"))}, nil
+	})
+	defer transport.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("X-Slow", "1")
+	if _, err := transport.RoundTrip(req.WithContext(ctx)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error must be the context error, actual %v", err)
+	}
+
+	close(release)
+	req, _ = http.NewRequest("GET", "http://example.com", nil)
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if atomic.LoadInt32(&body.closed) != 1 {
+		t.Error("the response of the abandoned request must be closed")
+	}
+}
+
+// blockedTransport returns the RateLimit of concurrency 1 whose first request is in flight until release is closed
+func blockedTransport(release chan struct{}, configure func(*RateLimit)) *RateLimit {
+	var sent int32
+	transport := NewMaxConcurrentTransport(1)
+	transport.Transport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&sent, 1)
+		<-release
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("ok"))}, nil
+	})
+	configure(transport)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://example.com", nil)
+		transport.RoundTrip(req)
+	}()
+	for atomic.LoadInt32(&sent) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return transport
+}
+
+// queue sends the request with the priority in background, and waits until it is queued
+func queue(transport *RateLimit, pr string) <-chan error {
+	pc := transport.waitCh("example.com")
+	n := pc.Len()
+	errCh := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set(DefaultPriorityHeaderName, pr)
+		_, err := transport.RoundTrip(req)
+		errCh <- err
+	}()
+	for pc.Len() == n {
+		time.Sleep(time.Millisecond)
+	}
+	return errCh
+}
+
+func TestRateLimitMaxQueueLength(t *testing.T) {
+	release := make(chan struct{})
+	transport := blockedTransport(release, func(transport *RateLimit) {
+		transport.MaxQueueLength = 2
+	})
+	defer transport.Close()
+
+	queued := []<-chan error{queue(transport, "normal"), queue(transport, "normal")}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if _, err := transport.RoundTrip(req); err != ErrQueueFull {
+		t.Errorf("error must be ErrQueueFull, actual %v", err)
+	}
+
+	close(release)
+	for _, errCh := range queued {
+		if err := <-errCh; err != nil {
+			t.Errorf("the queued requests must be sent, actual %v", err)
+		}
+	}
+}
+
+func TestRateLimitShedLowPriority(t *testing.T) {
+	release := make(chan struct{})
+	transport := blockedTransport(release, func(transport *RateLimit) {
+		transport.MaxQueueLength = 2
+		transport.ShedLowPriority = true
+	})
+	defer transport.Close()
+
+	low := queue(transport, "low")
+	normal := queue(transport, "normal")
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set(DefaultPriorityHeaderName, "low")
+	if _, err := transport.RoundTrip(req); err != ErrQueueFull {
+		t.Errorf("the Low priority request must be rejected, actual %v", err)
+	}
+
+	// The queue length does not change as the Low priority request is replaced.
+	high := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set(DefaultPriorityHeaderName, "high")
+		_, err := transport.RoundTrip(req)
+		high <- err
+	}()
+	if err := <-low; err != ErrQueueFull {
+		t.Errorf("the queued Low priority request must be shed, actual %v", err)
+	}
+
+	close(release)
+	if err := <-normal; err != nil {
+		t.Errorf("the Normal priority request must be sent, actual %v", err)
+	}
+	if err := <-high; err != nil {
+		t.Errorf("the High priority request must be sent, actual %v", err)
+	}
+}
+
+func TestRateLimitMaxQueueWait(t *testing.T) {
+	release := make(chan struct{})
+	transport := blockedTransport(release, func(transport *RateLimit) {
+		transport.MaxQueueWait = 50 * time.Millisecond
+	})
+	defer transport.Close()
+	defer close(release)
+
+	start := time.Now()
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if _, err := transport.RoundTrip(req); err != ErrQueueTimeout {
+		t.Errorf("error must be ErrQueueTimeout, actual %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the request must time out soon, but %s taken", elapsed)
+	}
+	if n := transport.waitCh("example.com").Len(); n != 0 {
+		t.Errorf("the timed out request must be removed from the queue, actual %d", n)
+	}
+}
@@ -0,0 +1,140 @@
+package limit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucketTransportFactory manages the RoundTripper
+// that cooperate with the each of themselves to limit rates of the requests with token buckets
+type TokenBucketTransportFactory struct {
+	// Rate is the number of the tokens added to the bucket per second. It must be positive
+	Rate float64
+	// Burst is the size of the bucket. If zero, 1 is used
+	Burst      int
+	closeCh    chan struct{}
+	channelMap map[string]*priorityChannel
+	ml         *sync.Mutex
+	initOnce   sync.Once
+}
+
+func (f *TokenBucketTransportFactory) init() {
+	if f.closeCh == nil {
+		f.closeCh = make(chan struct{})
+	}
+	if f.ml == nil {
+		f.ml = new(sync.Mutex)
+	}
+	if f.channelMap == nil {
+		f.channelMap = map[string]*priorityChannel{}
+	}
+}
+
+// NewTransport generates the RoundTripper
+// that cooperate with the each of themselves to limit rates of the requests with token buckets.
+// It panics if Rate is not positive
+func (f *TokenBucketTransportFactory) NewTransport() *RateLimit {
+	checkRate(f.Rate)
+	f.initOnce.Do(f.init)
+	return &RateLimit{
+		channelStarter: getTokenBucketStarter(f.Rate, f.Burst),
+		closeCh:        f.closeCh,
+		channelMap:     f.channelMap,
+		ml:             f.ml,
+	}
+}
+
+// NewTokenBucketTransport generates the RoundTripper
+// that limits rates of the requests in the groups with token buckets.
+// The bucket of each group holds up to burst tokens and gets rate tokens per second,
+// so that burst requests can be sent at once after idle.
+// It panics if rate is not positive, as the requests after the burst would wait forever.
+func NewTokenBucketTransport(rate float64, burst int) *RateLimit {
+	checkRate(rate)
+	return &RateLimit{
+		channelStarter: getTokenBucketStarter(rate, burst),
+	}
+}
+
+func checkRate(rate float64) {
+	if !(rate > 0) {
+		panic("limit: rate of the token bucket must be positive")
+	}
+}
+
+// tokenBucket is the bucket that is refilled continuously
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// wait returns the duration until a token is available
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+func (b *tokenBucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
+
+func getTokenBucketStarter(rate float64, burst int) channelStarter {
+	return func(_ string, closeCh chan struct{}) *priorityChannel {
+		pc := initPriorityChannel(closeCh)
+		bucket := newTokenBucket(rate, burst, time.Now())
+
+		go func() {
+			for {
+				if !waitUntil(closeCh, bucket.wait) {
+					return
+				}
+				req, ok := nextPayload(closeCh, pc)
+				if !ok {
+					return
+				}
+				bucket.take(time.Now())
+				go req.respond()
+			}
+		}()
+
+		return pc
+	}
+}
+
+// waitUntil waits until wait returns zero, and reports false if closeCh is closed
+func waitUntil(closeCh chan struct{}, wait func(time.Time) time.Duration) bool {
+	for {
+		d := wait(time.Now())
+		if d <= 0 {
+			return true
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-closeCh:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package limit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countTest struct {
+	requested []time.Time
+	l         sync.Mutex
+}
+
+func (ct *countTest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ct.l.Lock()
+	ct.requested = append(ct.requested, time.Now())
+	ct.l.Unlock()
+}
+
+// countWithin returns the max number of the requests within the window
+func (ct *countTest) countWithin(window time.Duration) int {
+	ct.l.Lock()
+	defer ct.l.Unlock()
+	max := 0
+	for i, start := range ct.requested {
+		n := 0
+		for _, t := range ct.requested[i:] {
+			if t.Sub(start) < window {
+				n++
+			}
+		}
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func TestTokenBucket(t *testing.T) {
+	ct := &countTest{}
+	s := httptest.NewServer(ct)
+	defer s.Close()
+
+	transport := NewTokenBucketTransport(20, 5)
+	defer transport.Close()
+	testClient := &http.Client{
+		Transport: transport,
+	}
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	numReq := 15
+	wg.Add(numReq)
+	for i := 0; i < numReq; i++ {
+		go func() {
+			testClient.Get(s.URL)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// 5 requests at once, and 10 requests in 500ms
+	if elapsed < 450*time.Millisecond {
+		t.Errorf("requests must be limited to the rate, but finished in %s", elapsed)
+	}
+	if n := ct.countWithin(40 * time.Millisecond); n < 5 {
+		t.Errorf("requests must burst, actual %d at once", n)
+	}
+}
+
+func TestTokenBucketFactory(t *testing.T) {
+	ct := &countTest{}
+	s := httptest.NewServer(ct)
+	defer s.Close()
+
+	factory := TokenBucketTransportFactory{
+		Rate:  10,
+		Burst: 2,
+	}
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	numReq := 6
+	wg.Add(numReq)
+	for i := 0; i < numReq; i++ {
+		go func() {
+			testClient := &http.Client{
+				Transport: factory.NewTransport(),
+			}
+			testClient.Get(s.URL)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	// 2 requests at once, and 4 requests in 400ms
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("transports must share the bucket, but finished in %s", elapsed)
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 2, now)
+	b.take(now)
+	b.take(now)
+	if d := b.wait(now); d != 100*time.Millisecond {
+		t.Errorf("a token must be available in 100ms, actual %s", d)
+	}
+	if d := b.wait(now.Add(time.Second)); d != 0 {
+		t.Errorf("a token must be available, actual %s", d)
+	}
+	if b.tokens != 2 {
+		t.Errorf("tokens must not exceed the burst, actual %f", b.tokens)
+	}
+}
+
+func TestTokenBucketRejectsZeroRate(t *testing.T) {
+	mustPanic := func(name string, f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s must panic without a positive rate", name)
+			}
+		}()
+		f()
+	}
+	mustPanic("NewTokenBucketTransport", func() { NewTokenBucketTransport(0, 5) })
+	mustPanic("NewTransport", func() { (&TokenBucketTransportFactory{Rate: -1, Burst: 5}).NewTransport() })
+}
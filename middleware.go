@@ -0,0 +1,179 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/wacul/transport/basicauth"
+	"github.com/wacul/transport/circuitbreaker"
+	"github.com/wacul/transport/expbackoff"
+	"github.com/wacul/transport/limit"
+	"github.com/wacul/transport/recover"
+)
+
+// Middleware wraps a RoundTripper with another RoundTripper.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// Unwrapper is implemented by the RoundTrippers that delegate requests to another RoundTripper.
+// Describe uses it to walk the assembled chain.
+type Unwrapper interface {
+	Unwrap() http.RoundTripper
+}
+
+type chainEntry struct {
+	name       string
+	middleware Middleware
+}
+
+// Chain composes Middlewares in the declared order.
+// The first Middleware of the chain receives the request first.
+// A Chain is immutable, so it can be shared and extended safely.
+type Chain struct {
+	entries []chainEntry
+}
+
+// NewChain creates a Chain of the Middlewares.
+func NewChain(middlewares ...Middleware) Chain {
+	return Chain{}.Append(middlewares...)
+}
+
+// Append returns a new Chain that has the Middlewares after the ones of c.
+func (c Chain) Append(middlewares ...Middleware) Chain {
+	entries := make([]chainEntry, 0, len(c.entries)+len(middlewares))
+	entries = append(entries, c.entries...)
+	for _, m := range middlewares {
+		entries = append(entries, chainEntry{middleware: m})
+	}
+	return Chain{entries: entries}
+}
+
+// AppendNamed returns a new Chain that has the named Middleware after the ones of c.
+// The name is shown by Names and String.
+func (c Chain) AppendNamed(name string, m Middleware) Chain {
+	entries := make([]chainEntry, 0, len(c.entries)+1)
+	entries = append(entries, c.entries...)
+	entries = append(entries, chainEntry{name: name, middleware: m})
+	return Chain{entries: entries}
+}
+
+// Then assembles the chain on the base RoundTripper.
+// If base is nil, http.DefaultTransport is used.
+func (c Chain) Then(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	rt := base
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		rt = c.entries[i].middleware(rt)
+	}
+	return rt
+}
+
+// Names returns the names of the Middlewares in the declared order.
+// Unnamed Middlewares are shown by their position.
+func (c Chain) Names() []string {
+	names := make([]string, len(c.entries))
+	for i, e := range c.entries {
+		if e.name == "" {
+			names[i] = fmt.Sprintf("#%d", i)
+		} else {
+			names[i] = e.name
+		}
+	}
+	return names
+}
+
+// String describes the chain like "basicauth -> expbackoff -> base".
+func (c Chain) String() string {
+	return strings.Join(append(c.Names(), "base"), " -> ")
+}
+
+// Describe walks the assembled RoundTripper through Unwrapper
+// and returns the types of the RoundTrippers from the outermost one.
+func Describe(rt http.RoundTripper) []string {
+	var types []string
+	for rt != nil {
+		types = append(types, fmt.Sprintf("%T", rt))
+		u, ok := rt.(Unwrapper)
+		if !ok {
+			break
+		}
+		next := u.Unwrap()
+		if next == rt {
+			break
+		}
+		rt = next
+	}
+	return types
+}
+
+// BasicAuth is the Middleware that authorizes requests with basicauth.Transport.
+func BasicAuth(userName, password string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &basicauth.Transport{
+			UserName: userName,
+			Password: password,
+			Base:     next,
+		}
+	}
+}
+
+// ExpBackoff is the Middleware that retries requests with a copy of t.
+// t.Transport is replaced by the next RoundTripper of the chain.
+func ExpBackoff(t *expbackoff.Transport) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		c := *t
+		c.Transport = next
+		return &c
+	}
+}
+
+// RateLimit is the Middleware that limits requests with t.
+// t.Transport is set to the next RoundTripper of the chain,
+// so t must not be in use yet and may be passed to exactly one Then.
+// It panics if t.Transport is already set.
+func RateLimit(t *limit.RateLimit) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		wire("RateLimit", &t.Transport, next)
+		return t
+	}
+}
+
+// Recover is the Middleware that uses spare if the next RoundTripper of the chain fails.
+// If useSpare is nil, the spare is used on errors.
+func Recover(spare http.RoundTripper, useSpare func(*http.Response, error) bool) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &recover.Transport{
+			Base:         next,
+			Spare:        spare,
+			UseSpareFunc: useSpare,
+		}
+	}
+}
+
+// CircuitBreaker is the Middleware that fails fast with t while the next RoundTripper is failing.
+// t.Transport is set to the next RoundTripper of the chain,
+// so t must not be in use yet and may be passed to exactly one Then.
+// It panics if t.Transport is already set.
+func CircuitBreaker(t *circuitbreaker.Transport) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		wire("CircuitBreaker", &t.Transport, next)
+		return t
+	}
+}
+
+// wireMu guards the Transport fields set by wire.
+var wireMu sync.Mutex
+
+// wire sets the transport of a shared RoundTripper to next once.
+// Setting it again would rewire the RoundTripper while it may be in use.
+func wire(name string, transport *http.RoundTripper, next http.RoundTripper) {
+	wireMu.Lock()
+	defer wireMu.Unlock()
+	if *transport != nil {
+		panic("transport: " + name + " is already wired to a RoundTripper")
+	}
+	*transport = next
+}
@@ -0,0 +1,90 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wacul/transport/expbackoff"
+	"github.com/wacul/transport/limit"
+)
+
+func headerMiddleware(value string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Add("X-Chain", value)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header["X-Chain"]
+	}))
+	defer s.Close()
+
+	c := NewChain(headerMiddleware("first"), headerMiddleware("second")).
+		AppendNamed("third", headerMiddleware("third"))
+	client := &http.Client{Transport: c.Then(nil)}
+	res, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+	res.Body.Close()
+
+	if !reflect.DeepEqual(got, []string{"first", "second", "third"}) {
+		t.Errorf("middlewares must be applied in the declared order, actual %v", got)
+	}
+	if c.String() != "#0 -> #1 -> third -> base" {
+		t.Errorf("unexpected description %q", c.String())
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	rl := limit.NewMaxConcurrentTransport(1)
+	defer rl.Close()
+
+	rt := NewChain(
+		BasicAuth("user", "pass"),
+		ExpBackoff(&expbackoff.Transport{Min: time.Millisecond, Max: time.Millisecond, Factor: 2}),
+		RateLimit(rl),
+		Recover(nil, nil),
+	).Then(nil)
+
+	want := []string{
+		"*basicauth.Transport",
+		"*expbackoff.Transport",
+		"*limit.RateLimit",
+		"*recover.Transport",
+		"*http.Transport",
+	}
+	if got := Describe(rt); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %s, actual %s", strings.Join(want, ","), strings.Join(got, ","))
+	}
+}
+
+func TestRateLimitWiredOnce(t *testing.T) {
+	rl := limit.NewMaxConcurrentTransport(1)
+	defer rl.Close()
+	c := NewChain(RateLimit(rl))
+
+	base := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, nil
+	})
+	c.Then(base)
+	if rl.Transport == nil {
+		t.Fatal("Transport must be the base")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("wiring again must panic")
+		}
+	}()
+	c.Then(base)
+}
@@ -0,0 +1,226 @@
+package recover
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/wacul/transport/internal/replay"
+)
+
+// Backend is a named RoundTripper that Failover tries.
+type Backend struct {
+	Name      string
+	Transport http.RoundTripper
+}
+
+func (b Backend) String() string {
+	if b.Name != "" {
+		return b.Name
+	}
+	return fmt.Sprintf("%T", b.transport())
+}
+
+func (b Backend) transport() http.RoundTripper {
+	if b.Transport == nil {
+		return http.DefaultTransport
+	}
+	return b.Transport
+}
+
+// BackendError is the failure of a backend.
+type BackendError struct {
+	Name string
+	// Response is the response classified as a failure, if any. Its Body is already closed.
+	Response *http.Response
+	Err      error
+}
+
+func (e BackendError) String() string {
+	switch {
+	case e.Err != nil:
+		return e.Name + ": " + e.Err.Error()
+	case e.Response != nil:
+		return e.Name + ": " + e.Response.Status
+	default:
+		return e.Name + ": failed"
+	}
+}
+
+// FailoverError is returned when all backends fail.
+type FailoverError struct {
+	Failures []BackendError
+}
+
+func (e *FailoverError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = f.String()
+	}
+	return "recover: all backends failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors of the backends.
+func (e *FailoverError) Unwrap() []error {
+	var errs []error
+	for _, f := range e.Failures {
+		if f.Err != nil {
+			errs = append(errs, f.Err)
+		}
+	}
+	return errs
+}
+
+// ErrNoBackends is returned by Failover without Backends.
+var ErrNoBackends = errors.New("recover: no backends")
+
+// ErrUnnamedBackend is returned by Failover with Health when a Backend has no Name.
+var ErrUnnamedBackend = errors.New("recover: backend must be named to check its health")
+
+// Failover is an implementation of the RoundTripper
+// that tries the Backends in order until one of them succeeds.
+type Failover struct {
+	Backends []Backend
+
+	// UseNextFunc check the response of a backend and decide whether to try the next one.
+	// If nil, the next one is tried on errors.
+	UseNextFunc func(*http.Response, error) bool
+
+	// OrderFunc returns the indexes of the n Backends in the order to try them for the request.
+	// If nil, they are tried in the declared order.
+	OrderFunc func(req *http.Request, n int) []int
+
+	// Health makes the unhealthy Backends skipped, like a HealthChecker watching them by their names.
+	// If all of them are unhealthy, they are all tried. All the Backends must have the Name.
+	Health Health
+
+	// MaxBodyMemory, MaxBodySpool and SpoolDir configure how the request body is replayed.
+	// See Transport.
+	MaxBodyMemory int64
+	MaxBodySpool  int64
+	SpoolDir      string
+
+	mu     sync.Mutex                      // guards modReq
+	modReq map[*http.Request]*http.Request // original -> in flight
+}
+
+// RandomOrder is the OrderFunc that tries the backends in a random order.
+func RandomOrder(req *http.Request, n int) []int {
+	return rand.Perm(n)
+}
+
+func (f *Failover) useNext(res *http.Response, err error) bool {
+	if f.UseNextFunc == nil {
+		return err != nil
+	}
+	return f.UseNextFunc(res, err)
+}
+
+func (f *Failover) order(req *http.Request) ([]int, error) {
+	var order []int
+	if f.OrderFunc != nil {
+		order = f.OrderFunc(req, len(f.Backends))
+	} else {
+		order = make([]int, len(f.Backends))
+		for i := range order {
+			order[i] = i
+		}
+	}
+	if f.Health == nil {
+		return order, nil
+	}
+
+	healthy := make([]int, 0, len(order))
+	for _, i := range order {
+		name := f.Backends[i].Name
+		if name == "" {
+			return nil, ErrUnnamedBackend
+		}
+		if f.Health.Healthy(name) {
+			healthy = append(healthy, i)
+		}
+	}
+	if len(healthy) == 0 {
+		return order, nil
+	}
+	return healthy, nil
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (f *Failover) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(f.Backends) == 0 {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrNoBackends
+	}
+	order, err := f.order(req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	body, err := newBody(req, f.MaxBodyMemory, f.MaxBodySpool, f.SpoolDir)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var failures []BackendError
+	for _, i := range order {
+		b := f.Backends[i]
+		breq, err := body.Request(req)
+		if err != nil {
+			failures = append(failures, BackendError{Name: b.String(), Err: err})
+			break
+		}
+		f.setModReq(req, breq)
+		res, err := b.transport().RoundTrip(breq)
+		if !f.useNext(res, err) {
+			return replay.OnClose(res, func() { f.setModReq(req, nil) }), err
+		}
+		closeResponse(res)
+		failures = append(failures, BackendError{Name: b.String(), Response: res, Err: err})
+		if req.Context().Err() != nil {
+			break
+		}
+	}
+	f.setModReq(req, nil)
+	return nil, &FailoverError{Failures: failures}
+}
+
+// CancelRequest cancels an in-flight request by closing its connection.
+// The copy of the request sent to the Backend is canceled.
+func (f *Failover) CancelRequest(req *http.Request) {
+	type canceller interface {
+		CancelRequest(*http.Request)
+	}
+	f.mu.Lock()
+	if modReq, ok := f.modReq[req]; ok {
+		req = modReq
+	}
+	f.mu.Unlock()
+	for _, b := range f.Backends {
+		if c, ok := b.transport().(canceller); ok {
+			c.CancelRequest(req)
+		}
+	}
+}
+
+func (f *Failover) setModReq(orig, mod *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.modReq == nil {
+		f.modReq = make(map[*http.Request]*http.Request)
+	}
+	if mod == nil {
+		delete(f.modReq, orig)
+	} else {
+		f.modReq[orig] = mod
+	}
+}
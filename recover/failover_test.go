@@ -0,0 +1,109 @@
+package recover
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func statusServer(status int, name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+		w.Write([]byte(name + ":" + string(bs)))
+	}))
+}
+
+// hostTransport sends the requests to the server whatever their URL is.
+func hostTransport(s *httptest.Server) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		r := new(http.Request)
+		*r = *req
+		u := *req.URL
+		u.Host = s.Listener.Addr().String()
+		r.URL = &u
+		return http.DefaultTransport.RoundTrip(r)
+	})
+}
+
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var errDown = errors.New("down")
+
+func downTransport() http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, errDown
+	})
+}
+
+func serverError(res *http.Response, err error) bool {
+	return err != nil || res.StatusCode >= 500
+}
+
+func TestFailover(t *testing.T) {
+	unavailable := statusServer(503, "unavailable")
+	defer unavailable.Close()
+	ok := statusServer(200, "ok")
+	defer ok.Close()
+
+	f := &Failover{
+		Backends: []Backend{
+			{Name: "down", Transport: downTransport()},
+			{Name: "unavailable", Transport: hostTransport(unavailable)},
+			{Name: "ok", Transport: hostTransport(ok)},
+		},
+		UseNextFunc: serverError,
+	}
+	client := &http.Client{Transport: f}
+	res, err := client.Post(ok.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	defer res.Body.Close()
+	bs, _ := ioutil.ReadAll(res.Body)
+	if string(bs) != "ok:body" {
+		t.Errorf("the body must be sent to the last backend, actual %q", bs)
+	}
+}
+
+func TestFailoverAllFail(t *testing.T) {
+	unavailable := statusServer(503, "unavailable")
+	defer unavailable.Close()
+
+	f := &Failover{
+		Backends: []Backend{
+			{Name: "down", Transport: downTransport()},
+			{Name: "unavailable", Transport: hostTransport(unavailable)},
+		},
+		UseNextFunc: serverError,
+		OrderFunc: func(req *http.Request, n int) []int {
+			return []int{1, 0}
+		},
+	}
+	client := &http.Client{Transport: f}
+	_, err := client.Get(unavailable.URL)
+
+	var fe *FailoverError
+	if !errors.As(err, &fe) {
+		t.Fatalf("error must be FailoverError, actual %v", err)
+	}
+	if len(fe.Failures) != 2 || fe.Failures[0].Name != "unavailable" || fe.Failures[0].Response.StatusCode != 503 || fe.Failures[1].Name != "down" {
+		t.Errorf("failures must be listed in the order tried, actual %v", fe.Failures)
+	}
+	if !errors.Is(err, errDown) {
+		t.Errorf("error must wrap the errors of the backends")
+	}
+	if !strings.Contains(err.Error(), "unavailable: 503 Service Unavailable; down: down") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
@@ -0,0 +1,227 @@
+package recover
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHealthInterval is the default interval between the probes of HealthChecker.
+	DefaultHealthInterval = 10 * time.Second
+	// DefaultHealthTimeout is the default timeout of a probe of HealthChecker.
+	DefaultHealthTimeout = 2 * time.Second
+)
+
+// Health reports whether the backend of the name is healthy.
+type Health interface {
+	Healthy(name string) bool
+}
+
+// HealthChecker probes the watched backends periodically and marks them healthy or unhealthy.
+// The backends are healthy until they fail Fall probes in a row,
+// and become healthy again when they pass Rise probes in a row.
+type HealthChecker struct {
+	// Path is requested by the probes. It is joined to the path of the watched URLs.
+	Path string
+	// ExpectedStatus is the status code of a passed probe. If zero, any 2xx passes.
+	ExpectedStatus int
+	// Interval is the interval between the probes. If zero, DefaultHealthInterval is used.
+	Interval time.Duration
+	// Timeout is the timeout of a probe. If zero, DefaultHealthTimeout is used.
+	Timeout time.Duration
+	// Rise is the number of the passed probes in a row to mark a backend healthy. If zero, 1 is used.
+	Rise int
+	// Fall is the number of the failed probes in a row to mark a backend unhealthy. If zero, 1 is used.
+	Fall int
+
+	Transport http.RoundTripper
+
+	// OnChange is called when a backend becomes healthy or unhealthy.
+	OnChange func(name string, healthy bool)
+
+	mu      sync.Mutex
+	targets map[string]*healthTarget
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type healthTarget struct {
+	url       string
+	healthy   bool
+	successes int
+	failures  int
+}
+
+func (h *HealthChecker) transport() http.RoundTripper {
+	if h.Transport == nil {
+		return http.DefaultTransport
+	}
+	return h.Transport
+}
+
+func (h *HealthChecker) interval() time.Duration {
+	if h.Interval <= 0 {
+		return DefaultHealthInterval
+	}
+	return h.Interval
+}
+
+func (h *HealthChecker) timeout() time.Duration {
+	if h.Timeout <= 0 {
+		return DefaultHealthTimeout
+	}
+	return h.Timeout
+}
+
+func threshold(n int) int {
+	if n <= 0 {
+		return 1
+	}
+	return n
+}
+
+// Watch adds the backend of the name to be probed at the base URL.
+func (h *HealthChecker) Watch(name, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	u.Path = joinPath(u.Path, h.Path)
+	u.RawPath = ""
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.targets == nil {
+		h.targets = make(map[string]*healthTarget)
+	}
+	h.targets[name] = &healthTarget{url: u.String(), healthy: true}
+	return nil
+}
+
+// Unwatch stops probing the backend of the name.
+func (h *HealthChecker) Unwatch(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.targets, name)
+}
+
+// Healthy reports whether the backend of the name is healthy.
+// The backends not watched are healthy.
+func (h *HealthChecker) Healthy(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.targets[name]
+	return !ok || t.healthy
+}
+
+// Start starts probing the backends every Interval in the background.
+func (h *HealthChecker) Start() {
+	h.mu.Lock()
+	if h.stop != nil {
+		h.mu.Unlock()
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	h.stop, h.done = stop, done
+	h.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(h.interval())
+		defer ticker.Stop()
+		for {
+			h.Check(context.Background())
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops probing the backends and waits for the probes in progress.
+func (h *HealthChecker) Stop() {
+	h.mu.Lock()
+	stop, done := h.stop, h.done
+	h.stop, h.done = nil, nil
+	h.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Check probes all the backends once and waits for the results.
+func (h *HealthChecker) Check(ctx context.Context) {
+	h.mu.Lock()
+	targets := make(map[string]string, len(h.targets))
+	for name, t := range h.targets {
+		targets[name] = t.url
+	}
+	h.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for name, u := range targets {
+		wg.Add(1)
+		go func(name, u string) {
+			defer wg.Done()
+			h.record(name, h.probe(ctx, u))
+		}(name, u)
+	}
+	wg.Wait()
+}
+
+func (h *HealthChecker) probe(ctx context.Context, u string) bool {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout())
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return false
+	}
+	res, err := h.transport().RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if h.ExpectedStatus == 0 {
+		return res.StatusCode >= 200 && res.StatusCode < 300
+	}
+	return res.StatusCode == h.ExpectedStatus
+}
+
+func (h *HealthChecker) record(name string, passed bool) {
+	h.mu.Lock()
+	t, ok := h.targets[name]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	changed := false
+	if passed {
+		t.successes++
+		t.failures = 0
+		if !t.healthy && t.successes >= threshold(h.Rise) {
+			t.healthy, changed = true, true
+		}
+	} else {
+		t.failures++
+		t.successes = 0
+		if t.healthy && t.failures >= threshold(h.Fall) {
+			t.healthy, changed = false, true
+		}
+	}
+	healthy := t.healthy
+	h.mu.Unlock()
+
+	if changed && h.OnChange != nil {
+		h.OnChange(name, healthy)
+	}
+}
@@ -0,0 +1,144 @@
+package recover
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker(t *testing.T) {
+	var status int32 = 200
+	var path atomic.Value
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path.Store(r.URL.Path)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer s.Close()
+
+	var changes []bool
+	h := &HealthChecker{
+		Path: "/healthz",
+		Rise: 2,
+		Fall: 2,
+		OnChange: func(name string, healthy bool) {
+			changes = append(changes, healthy)
+		},
+	}
+	if err := h.Watch("primary", s.URL+"/api"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	atomic.StoreInt32(&status, 503)
+	h.Check(ctx)
+	if !h.Healthy("primary") {
+		t.Error("a backend must stay healthy until Fall probes fail")
+	}
+	if p, _ := path.Load().(string); p != "/api/healthz" {
+		t.Errorf("the Path must be joined, actual %q", p)
+	}
+	h.Check(ctx)
+	if h.Healthy("primary") {
+		t.Error("a backend must be unhealthy after Fall probes fail")
+	}
+
+	atomic.StoreInt32(&status, 200)
+	h.Check(ctx)
+	if h.Healthy("primary") {
+		t.Error("a backend must stay unhealthy until Rise probes pass")
+	}
+	h.Check(ctx)
+	if !h.Healthy("primary") {
+		t.Error("a backend must be healthy after Rise probes pass")
+	}
+
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Errorf("OnChange must be called on changes, actual %v", changes)
+	}
+	if !h.Healthy("unknown") {
+		t.Error("the backends not watched must be healthy")
+	}
+}
+
+func TestHealthCheckerStart(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer s.Close()
+
+	h := &HealthChecker{Interval: 10 * time.Millisecond, ExpectedStatus: 204}
+	h.Watch("primary", s.URL)
+	h.Start()
+	defer h.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for h.Healthy("primary") {
+		if time.Now().After(deadline) {
+			t.Fatal("a backend must become unhealthy in background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type healthMap map[string]bool
+
+func (m healthMap) Healthy(name string) bool {
+	return m[name]
+}
+
+func TestFailoverHealth(t *testing.T) {
+	primary := statusServer(200, "primary")
+	defer primary.Close()
+	secondary := statusServer(200, "secondary")
+	defer secondary.Close()
+
+	health := healthMap{"primary": false, "secondary": true}
+	f := &Failover{
+		Backends: []Backend{
+			{Name: "primary", Transport: hostTransport(primary)},
+			{Name: "secondary", Transport: hostTransport(secondary)},
+		},
+		Health: health,
+	}
+	client := &http.Client{Transport: f}
+
+	get := func() string {
+		res, err := client.Get(primary.URL)
+		if err != nil {
+			t.Fatalf("error must be nil: %s", err)
+		}
+		defer res.Body.Close()
+		bs, _ := ioutil.ReadAll(res.Body)
+		return string(bs)
+	}
+
+	if body := get(); body != "secondary:" {
+		t.Errorf("the unhealthy backend must be skipped, actual %q", body)
+	}
+	health["primary"] = true
+	if body := get(); body != "primary:" {
+		t.Errorf("the recovered primary must be used, actual %q", body)
+	}
+	health["primary"], health["secondary"] = false, false
+	if body := get(); body != "primary:" {
+		t.Errorf("all backends must be tried if all are unhealthy, actual %q", body)
+	}
+}
+
+func TestFailoverHealthUnnamed(t *testing.T) {
+	f := &Failover{
+		Backends: []Backend{
+			{Name: "primary", Transport: downTransport()},
+			{Transport: downTransport()},
+		},
+		Health: healthMap{"primary": true},
+	}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if _, err := f.RoundTrip(req); err != ErrUnnamedBackend {
+		t.Errorf("the unnamed backend must be rejected, actual %v", err)
+	}
+}
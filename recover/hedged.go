@@ -0,0 +1,270 @@
+package recover
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wacul/transport/internal/idempotency"
+	"github.com/wacul/transport/internal/replay"
+)
+
+const (
+	// maxLatencySamples is the number of the latest latencies kept by Hedged.
+	maxLatencySamples = 100
+	// DefaultMinSamples is the default number of the latencies Hedged needs to use the Percentile.
+	DefaultMinSamples = 10
+)
+
+// Hedged is an implementation of the RoundTripper
+// that sends a copy of the request to the Hedge if the Transport has not answered within a delay,
+// and takes the first successful response. The other request is canceled.
+// If the first request fails before the delay, the copy is sent at once.
+// Only the requests safe to send twice are hedged: the idempotent methods
+// (GET, HEAD, OPTIONS, PUT and DELETE) and the ones with the idempotency key header.
+type Hedged struct {
+	Transport http.RoundTripper
+	// Hedge sends the copy of the request. If nil, the Transport is used.
+	Hedge http.RoundTripper
+
+	// Delay is the delay before sending the copy.
+	// If zero, the copy is sent only after the first request fails.
+	Delay time.Duration
+	// Percentile makes the delay the percentile of the latencies of the successful requests,
+	// like 0.95 for the 95th percentile, once MinSamples latencies are observed.
+	// Delay is used until then. Zero disables it.
+	Percentile float64
+	// MinSamples is the number of the latencies needed to use the Percentile.
+	// If zero, DefaultMinSamples is used.
+	MinSamples int
+
+	// HedgeNonIdempotent hedges the requests that are not safe to send twice, like POST.
+	HedgeNonIdempotent bool
+	// IdempotencyKeyHeader is the name of the idempotency key header.
+	// If empty, "Idempotency-Key" is used.
+	IdempotencyKeyHeader string
+
+	// FailureFunc check the response and decide whether it is a failure.
+	// If nil, errors are failures.
+	FailureFunc func(*http.Response, error) bool
+
+	// MaxBodyMemory, MaxBodySpool and SpoolDir configure how the request body is replayed.
+	// See Transport. The copy is not sent if the body is not replayable.
+	MaxBodyMemory int64
+	MaxBodySpool  int64
+	SpoolDir      string
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+	inFlight  map[*http.Request]context.CancelFunc // original -> cancel of the copies
+}
+
+type hedgeResult struct {
+	index   int
+	res     *http.Response
+	err     error
+	cancel  context.CancelFunc
+	latency time.Duration
+}
+
+func (h *Hedged) transport() http.RoundTripper {
+	if h.Transport == nil {
+		return http.DefaultTransport
+	}
+	return h.Transport
+}
+
+func (h *Hedged) hedge() http.RoundTripper {
+	if h.Hedge == nil {
+		return h.transport()
+	}
+	return h.Hedge
+}
+
+// Unwrap returns the Transport.
+func (h *Hedged) Unwrap() http.RoundTripper {
+	return h.transport()
+}
+
+func (h *Hedged) failed(res *http.Response, err error) bool {
+	if h.FailureFunc == nil {
+		return err != nil
+	}
+	return h.FailureFunc(res, err)
+}
+
+// CurrentDelay returns the delay before sending the copy.
+func (h *Hedged) CurrentDelay() time.Duration {
+	if h.Percentile <= 0 {
+		return h.Delay
+	}
+	minSamples := h.MinSamples
+	if minSamples <= 0 {
+		minSamples = DefaultMinSamples
+	}
+
+	h.mu.Lock()
+	samples := append([]time.Duration(nil), h.latencies...)
+	h.mu.Unlock()
+	if len(samples) == 0 || len(samples) < minSamples {
+		return h.Delay
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(math.Ceil(math.Min(h.Percentile, 1)*float64(len(samples)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return samples[i]
+}
+
+func (h *Hedged) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < maxLatencySamples {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % maxLatencySamples
+}
+
+func (h *Hedged) hedgeable(req *http.Request) bool {
+	return h.HedgeNonIdempotent || idempotency.Safe(req, h.IdempotencyKeyHeader)
+}
+
+// RoundTrip implements the RoundTripper interface.
+// If the copy cannot be made, its error is returned unless the Transport succeeds.
+func (h *Hedged) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !h.hedgeable(req) {
+		return h.transport().RoundTrip(req)
+	}
+	body, err := newBody(req, h.MaxBodyMemory, h.MaxBodySpool, h.SpoolDir)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	ctx, cancelAll := context.WithCancel(req.Context())
+	h.setInFlight(req, cancelAll)
+	finish := func(cancel context.CancelFunc) func() {
+		return func() {
+			cancel()
+			h.setInFlight(req, nil)
+			cancelAll()
+		}
+	}
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	launch := func(rt http.RoundTripper) error {
+		areq, err := body.Request(req)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		start := time.Now()
+		go func() {
+			res, err := rt.RoundTrip(areq.WithContext(ctx))
+			results <- hedgeResult{index: index, res: res, err: err, cancel: cancel, latency: time.Since(start)}
+		}()
+		return nil
+	}
+	var hedgeErr error
+	canHedge := func() bool {
+		return len(cancels) < 2 && hedgeErr == nil && body.Replayable() && ctx.Err() == nil
+	}
+
+	if err := launch(h.transport()); err != nil {
+		h.setInFlight(req, nil)
+		cancelAll()
+		return nil, err
+	}
+	var hedgeAfter <-chan time.Time
+	if d := h.CurrentDelay(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		hedgeAfter = timer.C
+	}
+
+	var last *hedgeResult
+	for received := 0; received < len(cancels); {
+		select {
+		case <-hedgeAfter:
+			if canHedge() {
+				hedgeErr = launch(h.hedge())
+			}
+		case r := <-results:
+			received++
+			if !h.failed(r.res, r.err) {
+				h.observe(r.latency)
+				if last != nil {
+					closeResponse(last.res)
+				}
+				abandon(results, len(cancels)-received, cancels, r.index)
+				return replay.OnClose(r.res, finish(r.cancel)), r.err
+			}
+			if last != nil {
+				closeResponse(last.res)
+				last.cancel()
+			}
+			last = &r
+			if canHedge() {
+				hedgeErr = launch(h.hedge())
+			}
+		}
+	}
+	if hedgeErr != nil {
+		closeResponse(last.res)
+		finish(last.cancel)()
+		return nil, hedgeErr
+	}
+	return replay.OnClose(last.res, finish(last.cancel)), last.err
+}
+
+// CancelRequest cancels the in-flight copies of the request.
+func (h *Hedged) CancelRequest(req *http.Request) {
+	h.mu.Lock()
+	cancel := h.inFlight[req]
+	h.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (h *Hedged) setInFlight(req *http.Request, cancel context.CancelFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.inFlight == nil {
+		h.inFlight = make(map[*http.Request]context.CancelFunc)
+	}
+	if cancel == nil {
+		delete(h.inFlight, req)
+	} else {
+		h.inFlight[req] = cancel
+	}
+}
+
+// abandon cancels the requests in flight except the winner, and closes their responses.
+// The winner is canceled when its body is closed.
+func abandon(results <-chan hedgeResult, inFlight int, cancels []context.CancelFunc, winner int) {
+	for i, cancel := range cancels {
+		if i != winner {
+			cancel()
+		}
+	}
+	if inFlight == 0 {
+		return
+	}
+	go func() {
+		for i := 0; i < inFlight; i++ {
+			closeResponse((<-results).res)
+		}
+	}()
+}
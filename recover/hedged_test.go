@@ -0,0 +1,255 @@
+package recover
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHedged(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		case <-time.After(time.Second):
+			w.Write([]byte("slow"))
+		}
+	}))
+	defer slow.Close()
+	fast := statusServer(200, "fast")
+	defer fast.Close()
+
+	h := &Hedged{
+		Transport: hostTransport(slow),
+		Hedge:     hostTransport(fast),
+		Delay:     20 * time.Millisecond,
+
+		HedgeNonIdempotent: true,
+	}
+	client := &http.Client{Transport: h}
+
+	start := time.Now()
+	res, err := client.Post(slow.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	bs, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(bs) != "fast:body" {
+		t.Errorf("the hedge must win, actual %q", bs)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("the hedge must answer soon, but %s taken", d)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(500 * time.Millisecond):
+		t.Error("the loser must be canceled")
+	}
+}
+
+func TestHedgedNotSentWhenFast(t *testing.T) {
+	primary := statusServer(200, "primary")
+	defer primary.Close()
+	hedged := 0
+	hedge := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		hedged++
+		return nil, errDown
+	})
+
+	h := &Hedged{
+		Transport: hostTransport(primary),
+		Hedge:     hedge,
+		Delay:     time.Second,
+	}
+	client := &http.Client{Transport: h}
+	res, err := client.Get(primary.URL)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+	if hedged != 0 {
+		t.Errorf("the copy must not be sent, actual %d", hedged)
+	}
+}
+
+func TestHedgedNotIdempotent(t *testing.T) {
+	primary := statusServer(200, "primary")
+	defer primary.Close()
+	hedged := 0
+	hedge := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		hedged++
+		return nil, errDown
+	})
+
+	h := &Hedged{
+		Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			time.Sleep(50 * time.Millisecond)
+			return hostTransport(primary).RoundTrip(req)
+		}),
+		Hedge: hedge,
+		Delay: time.Millisecond,
+	}
+	client := &http.Client{Transport: h}
+	res, err := client.Post(primary.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+	if hedged != 0 {
+		t.Errorf("POST must not be hedged, actual %d", hedged)
+	}
+
+	req, _ := http.NewRequest("POST", primary.URL, strings.NewReader("body"))
+	req.Header.Set("Idempotency-Key", "key")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+	if hedged != 1 {
+		t.Errorf("POST with the Idempotency-Key must be hedged, actual %d", hedged)
+	}
+
+	h.IdempotencyKeyHeader = "X-Request-Id"
+	req, _ = http.NewRequest("POST", primary.URL, strings.NewReader("body"))
+	req.Header.Set("Idempotency-Key", "key")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+	if hedged != 1 {
+		t.Errorf("the Idempotency-Key must be ignored with IdempotencyKeyHeader, actual %d", hedged)
+	}
+}
+
+func TestHedgedZeroDelay(t *testing.T) {
+	primary := statusServer(200, "primary")
+	defer primary.Close()
+	hedged := 0
+	h := &Hedged{
+		Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			time.Sleep(20 * time.Millisecond)
+			return hostTransport(primary).RoundTrip(req)
+		}),
+		Hedge: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			hedged++
+			return nil, errDown
+		}),
+	}
+	client := &http.Client{Transport: h}
+	res, err := client.Get(primary.URL)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+	if hedged != 0 {
+		t.Errorf("the copy must not be sent without Delay, actual %d", hedged)
+	}
+}
+
+type closeRecorder struct {
+	closed chan struct{}
+}
+
+func (c *closeRecorder) Read(p []byte) (int, error) { return 0, io.EOF }
+func (c *closeRecorder) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestHedgedClosesFailure(t *testing.T) {
+	failure := &closeRecorder{closed: make(chan struct{})}
+	primary := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 503, Body: failure}, nil
+	})
+	hedge := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("hedge"))}, nil
+	})
+
+	h := &Hedged{Transport: primary, Hedge: hedge, Delay: time.Second, FailureFunc: serverError}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	res, err := h.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Errorf("the hedge must win, actual %d", res.StatusCode)
+	}
+	select {
+	case <-failure.closed:
+	default:
+		t.Error("the failed response must be closed")
+	}
+}
+
+func TestHedgedCopyError(t *testing.T) {
+	primary := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errDown
+	})
+	errGetBody := errors.New("get body")
+	req, _ := http.NewRequest("GET", "http://example.com", strings.NewReader("body"))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return nil, errGetBody
+	}
+
+	h := &Hedged{Transport: primary, Delay: time.Second}
+	if _, err := h.RoundTrip(req); err != errGetBody {
+		t.Errorf("the error of the copy must be returned, actual %v", err)
+	}
+}
+
+func TestHedgedCancelRequest(t *testing.T) {
+	sent := make(chan struct{})
+	primary := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		close(sent)
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+
+	h := &Hedged{Transport: primary, Hedge: downTransport(), Delay: time.Second}
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	done := make(chan error)
+	go func() {
+		_, err := h.RoundTrip(req)
+		done <- err
+	}()
+	<-sent
+	h.CancelRequest(req)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the copy in flight must be canceled")
+	}
+	h.mu.Lock()
+	n := len(h.inFlight)
+	h.mu.Unlock()
+	if n != 0 {
+		t.Errorf("the request must be forgotten after the round trip, actual %d", n)
+	}
+}
+
+func TestHedgedPercentileDelay(t *testing.T) {
+	h := &Hedged{Delay: time.Second, Percentile: 0.9, MinSamples: 10}
+	for i := 1; i <= 9; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := h.CurrentDelay(); d != time.Second {
+		t.Errorf("Delay must be used under MinSamples, actual %s", d)
+	}
+	h.observe(10 * time.Millisecond)
+	if d := h.CurrentDelay(); d != 9*time.Millisecond {
+		t.Errorf("the 90th percentile must be 9ms, actual %s", d)
+	}
+}
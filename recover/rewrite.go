@@ -0,0 +1,116 @@
+package recover
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Rewriter is an implementation of the RoundTripper
+// that sends the requests to the Target instead of their own host,
+// so that a spare or a backend can be another endpoint like a DR region or a mirror.
+type Rewriter struct {
+	// Target is the base URL. Its scheme and host replace the ones of the request
+	// including the Host header, its path is prepended to the path of the request,
+	// and its query is added to the query of the request.
+	Target *url.URL
+	// StripPrefix is removed from the path of the request before the path of the Target is prepended.
+	StripPrefix string
+
+	Transport http.RoundTripper
+}
+
+// ErrInvalidTarget is returned by NewRewriter when the target has no scheme or host.
+var ErrInvalidTarget = errors.New("recover: target must be an absolute URL")
+
+// NewRewriter creates the Rewriter to the base URL.
+// The URL must have the scheme and the host.
+func NewRewriter(target string, rt http.RoundTripper) (*Rewriter, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, ErrInvalidTarget
+	}
+	return &Rewriter{Target: u, Transport: rt}, nil
+}
+
+// URLBackend creates the Backend that sends the requests to the base URL.
+func URLBackend(name, target string, rt http.RoundTripper) (Backend, error) {
+	r, err := NewRewriter(target, rt)
+	if err != nil {
+		return Backend{}, err
+	}
+	return Backend{Name: name, Transport: r}, nil
+}
+
+func (r *Rewriter) transport() http.RoundTripper {
+	if r.Transport == nil {
+		return http.DefaultTransport
+	}
+	return r.Transport
+}
+
+// Unwrap returns the RoundTripper that Rewriter delegates requests to.
+func (r *Rewriter) Unwrap() http.RoundTripper {
+	return r.transport()
+}
+
+// RoundTrip implements the RoundTripper interface.
+func (r *Rewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.transport().RoundTrip(r.rewrite(req))
+}
+
+// rewrite returns a shallow copy of req to the Target.
+func (r *Rewriter) rewrite(req *http.Request) *http.Request {
+	u := *req.URL
+	u.Scheme = r.Target.Scheme
+	u.Host = r.Target.Host
+	u.User = r.Target.User
+
+	p, rp := req.URL.Path, req.URL.EscapedPath()
+	if r.StripPrefix != "" {
+		p = strings.TrimPrefix(p, r.StripPrefix)
+		rp = strings.TrimPrefix(rp, r.StripPrefix)
+	}
+	u.Path = joinPath(r.Target.Path, p)
+	if rp != p || r.Target.RawPath != "" {
+		u.RawPath = joinPath(r.Target.EscapedPath(), rp)
+	} else {
+		u.RawPath = ""
+	}
+
+	switch {
+	case r.Target.RawQuery == "":
+	case u.RawQuery == "":
+		u.RawQuery = r.Target.RawQuery
+	default:
+		u.RawQuery = r.Target.RawQuery + "&" + u.RawQuery
+	}
+
+	r2 := new(http.Request)
+	*r2 = *req
+	r2.URL = &u
+	r2.Host = r.Target.Host
+	return r2
+}
+
+func joinPath(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,72 @@
+package recover
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRewriter(t *testing.T) {
+	cases := []struct {
+		target, strip, url, want string
+	}{
+		{"https://api-dr.example.com", "", "http://api.example.com/v1/items?page=2", "https://api-dr.example.com/v1/items?page=2"},
+		{"https://mirror.example.com/api/", "", "http://api.example.com/v1/items", "https://mirror.example.com/api/v1/items"},
+		{"https://mirror.example.com/v2?key=k", "/v1", "http://api.example.com/v1/items?page=2", "https://mirror.example.com/v2/items?key=k&page=2"},
+		{"https://mirror.example.com/api", "", "http://api.example.com/a%2Fb", "https://mirror.example.com/api/a%2Fb"},
+	}
+	for _, c := range cases {
+		r, err := NewRewriter(c.target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.StripPrefix = c.strip
+		req, _ := http.NewRequest("GET", c.url, nil)
+		r2 := r.rewrite(req)
+		if got := r2.URL.String(); got != c.want {
+			t.Errorf("%s must be rewritten to %s, actual %s", c.url, c.want, got)
+		}
+		if r2.Host != r.Target.Host {
+			t.Errorf("Host must be %s, actual %s", r.Target.Host, r2.Host)
+		}
+		if req.URL.String() != c.url {
+			t.Errorf("the request must not be modified")
+		}
+	}
+}
+
+func TestNewRewriterInvalidTarget(t *testing.T) {
+	for _, target := range []string{"api-dr.example.com", "/api", "https://", ""} {
+		if _, err := NewRewriter(target, nil); err != ErrInvalidTarget {
+			t.Errorf("%q must be rejected, actual %v", target, err)
+		}
+		if _, err := URLBackend("dr", target, nil); err != ErrInvalidTarget {
+			t.Errorf("URLBackend must reject %q, actual %v", target, err)
+		}
+	}
+}
+
+func TestTransportRewriteSpare(t *testing.T) {
+	var host string
+	dr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer dr.Close()
+
+	spare, err := NewRewriter(dr.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &Transport{
+		Base:  downTransport(),
+		Spare: spare,
+	}}
+	res, err := client.Get("http://api.example.invalid/items")
+	if err != nil {
+		t.Fatalf("error must be nil: %s", err)
+	}
+	res.Body.Close()
+	if host != dr.Listener.Addr().String() {
+		t.Errorf("the spare must receive the rewritten Host, actual %s", host)
+	}
+}
@@ -1,19 +1,36 @@
 package recover
 
 import (
-	"bytes"
-	"io/ioutil"
 	"net/http"
+	"sync"
+
+	"github.com/wacul/transport/internal/replay"
 )
 
+// ErrBodyNotReplayable is returned when the Spare should be used
+// but the request body is too large to be sent again.
+var ErrBodyNotReplayable = replay.ErrNotReplayable
+
 // Transport is an implementation of the RoundTripper.
 // That uses the Base and Spare alternatively if the first one gets the error.
+// A Rewriter as the Spare sends the request to another endpoint.
 type Transport struct {
 	Base  http.RoundTripper
 	Spare http.RoundTripper
 
 	// UseSpareFunc check the response of the Base and decide whether to use spare.
 	UseSpareFunc func(*http.Response, error) bool
+
+	// MaxBodyMemory limits the size of the request body buffered in memory to send it to the Spare
+	// when the request has no GetBody. If zero, 1MiB is used. If negative, the size is not limited.
+	MaxBodyMemory int64
+	// MaxBodySpool limits the size of the request body spooled to a temporary file in SpoolDir
+	// when it exceeds MaxBodyMemory. If zero, the body is not spooled to a file.
+	MaxBodySpool int64
+	SpoolDir     string
+
+	mu     sync.Mutex                      // guards modReq
+	modReq map[*http.Request]*http.Request // original -> in flight
 }
 
 func (f *Transport) base() http.RoundTripper {
@@ -30,11 +47,22 @@ func (f *Transport) spare() http.RoundTripper {
 	return f.Spare
 }
 
+// Unwrap returns the Base RoundTripper.
+func (f *Transport) Unwrap() http.RoundTripper {
+	return f.base()
+}
+
 // CancelRequest cancels an in-flight request by closing its connection.
+// The copy of the request sent to the Base or the Spare is canceled.
 func (f *Transport) CancelRequest(req *http.Request) {
 	type canceller interface {
 		CancelRequest(*http.Request)
 	}
+	f.mu.Lock()
+	if modReq, ok := f.modReq[req]; ok {
+		req = modReq
+	}
+	f.mu.Unlock()
 	if c, ok := f.base().(canceller); ok {
 		c.CancelRequest(req)
 	}
@@ -43,6 +71,19 @@ func (f *Transport) CancelRequest(req *http.Request) {
 	}
 }
 
+func (f *Transport) setModReq(orig, mod *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.modReq == nil {
+		f.modReq = make(map[*http.Request]*http.Request)
+	}
+	if mod == nil {
+		delete(f.modReq, orig)
+	} else {
+		f.modReq[orig] = mod
+	}
+}
+
 func (f *Transport) useSpare(res *http.Response, err error) bool {
 	if f.UseSpareFunc == nil {
 		return err != nil
@@ -52,24 +93,49 @@ func (f *Transport) useSpare(res *http.Response, err error) bool {
 
 // RoundTrip implements the RoundTripper interface.
 func (f *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	var rb []byte
-	hasBody := req.Body != nil
-	if hasBody {
-		_rb, err := ioutil.ReadAll(req.Body)
-		if err != nil {
-			return nil, err
-		}
-		rb = _rb
-		req.Body.Close()
-		req.Body = ioutil.NopCloser(bytes.NewBuffer(rb))
+	body, err := newBody(req, f.MaxBodyMemory, f.MaxBodySpool, f.SpoolDir)
+	if err != nil {
+		return nil, err
 	}
+	defer body.Close()
+
+	breq, err := body.Request(req)
+	if err != nil {
+		return nil, err
+	}
+	f.setModReq(req, breq)
+	res, err := f.base().RoundTrip(breq)
+	if !f.useSpare(res, err) {
+		return f.release(req, res, err)
+	}
+
+	closeResponse(res)
+	sreq, err := body.Request(req)
+	if err != nil {
+		f.setModReq(req, nil)
+		return nil, err
+	}
+	f.setModReq(req, sreq)
+	res, err = f.spare().RoundTrip(sreq)
+	return f.release(req, res, err)
+}
+
+// release forgets the request in flight when the response is done.
+func (f *Transport) release(req *http.Request, res *http.Response, err error) (*http.Response, error) {
+	return replay.OnClose(res, func() { f.setModReq(req, nil) }), err
+}
+
+func newBody(req *http.Request, maxMemory, maxSpool int64, dir string) (*replay.Body, error) {
+	return replay.New(req, replay.Options{
+		MaxMemory: maxMemory,
+		MaxFile:   maxSpool,
+		Dir:       dir,
+	})
+}
 
-	res, err := f.base().RoundTrip(req)
-	if f.useSpare(res, err) {
-		if hasBody {
-			req.Body = ioutil.NopCloser(bytes.NewBuffer(rb))
-		}
-		return f.spare().RoundTrip(req)
+// closeResponse closes the body of the response that is not returned.
+func closeResponse(res *http.Response) {
+	if res != nil && res.Body != nil {
+		res.Body.Close()
 	}
-	return res, err
 }
@@ -0,0 +1,76 @@
+package recover
+
+import (
+	"net/http"
+	"testing"
+)
+
+// cancelRecorder blocks the request until it is canceled, and records the canceled request.
+type cancelRecorder struct {
+	sent     chan *http.Request
+	canceled chan *http.Request
+	got      *http.Request
+}
+
+func (c *cancelRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.sent <- req
+	c.got = <-c.canceled
+	return nil, errDown
+}
+
+func (c *cancelRecorder) CancelRequest(req *http.Request) {
+	c.canceled <- req
+}
+
+func TestTransportCancelRequest(t *testing.T) {
+	base := &cancelRecorder{sent: make(chan *http.Request, 1), canceled: make(chan *http.Request, 1)}
+	f := &Transport{Base: base, Spare: downTransport()}
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	done := make(chan struct{})
+	go func() {
+		f.RoundTrip(req)
+		close(done)
+	}()
+	sent := <-base.sent
+	f.CancelRequest(req)
+	<-done
+
+	if sent == req {
+		t.Fatal("the Base must receive a copy of the request")
+	}
+	if base.got != sent {
+		t.Error("the copy in flight must be canceled")
+	}
+	f.mu.Lock()
+	n := len(f.modReq)
+	f.mu.Unlock()
+	if n != 0 {
+		t.Errorf("the request must be forgotten after the round trip, actual %d", n)
+	}
+}
+
+func TestFailoverCancelRequest(t *testing.T) {
+	backend := &cancelRecorder{sent: make(chan *http.Request, 1), canceled: make(chan *http.Request, 1)}
+	f := &Failover{Backends: []Backend{{Name: "primary", Transport: backend}}}
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	done := make(chan struct{})
+	go func() {
+		f.RoundTrip(req)
+		close(done)
+	}()
+	sent := <-backend.sent
+	f.CancelRequest(req)
+	<-done
+
+	if backend.got != sent || sent == req {
+		t.Error("the copy in flight must be canceled")
+	}
+	f.mu.Lock()
+	n := len(f.modReq)
+	f.mu.Unlock()
+	if n != 0 {
+		t.Errorf("the request must be forgotten after the round trip, actual %d", n)
+	}
+}